@@ -3,6 +3,7 @@ package markdown
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/cozy/prosemirror-go/model"
@@ -26,6 +27,24 @@ type MarkdownParseState struct {
 	Schema *model.Schema
 	Root   *model.Node
 	Stack  []*StackItem
+
+	// SoftBreak is the text added for a soft line break, and SoftBreakNode
+	// is the name of the node type to add instead, if not empty (see the
+	// options of ParseMarkdown).
+	SoftBreak     string
+	SoftBreakNode string
+}
+
+// newParseState creates the state for parsing source with the given options.
+func newParseState(source []byte, schema *model.Schema, options []map[string]interface{}) *MarkdownParseState {
+	state := &MarkdownParseState{Source: source, Schema: schema, SoftBreak: "\n"}
+	if len(options) > 0 {
+		if str, ok := options[0]["softBreak"].(string); ok {
+			state.SoftBreak = str
+		}
+		state.SoftBreakNode, _ = options[0]["softBreakNode"].(string)
+	}
+	return state
 }
 
 type StackItem struct {
@@ -63,8 +82,11 @@ func (state *MarkdownParseState) Pop() *StackItem {
 }
 
 // AddText adds the given text to the current position in the document, using
-// the current marks as styling.
+// the current marks as styling. Empty text is ignored.
 func (state *MarkdownParseState) AddText(text string) {
+	if text == "" {
+		return
+	}
 	top := state.Top()
 	node := state.Schema.Text(text, top.Marks)
 	if len(top.Content) > 0 {
@@ -96,6 +118,14 @@ func (state *MarkdownParseState) AddNode(typ *model.NodeType, attrs map[string]i
 		marks = top.Marks
 	}
 	node, err := typ.CreateAndFill(attrs, content, marks)
+	if node == nil && err == nil {
+		// The content may need to be wrapped in some intermediate nodes
+		// that were not emitted by the markdown parser.
+		var wrapped *model.Fragment
+		if wrapped, err = wrapContent(typ, content); wrapped != nil {
+			node, err = typ.CreateAndFill(attrs, wrapped, marks)
+		}
+	}
 	if node == nil {
 		return nil, err
 	}
@@ -103,6 +133,61 @@ func (state *MarkdownParseState) AddNode(typ *model.NodeType, attrs map[string]i
 	return node, nil
 }
 
+// wrapContent tries to make the given content fit in a node of the given type
+// by wrapping the children that don't fit in the nodes found by
+// ContentMatch.FindWrapping. It returns nil if it doesn't work.
+func wrapContent(typ *model.NodeType, content interface{}) (*model.Fragment, error) {
+	frag, err := model.FragmentFrom(content)
+	if err != nil {
+		return nil, err
+	}
+	var result []*model.Node
+	match := typ.ContentMatch
+	for i := 0; i < frag.ChildCount(); {
+		child, err := frag.Child(i)
+		if err != nil {
+			return nil, err
+		}
+		if next := match.MatchType(child.Type); next != nil {
+			result = append(result, child)
+			match = next
+			i++
+			continue
+		}
+		wrap := match.FindWrapping(child.Type)
+		if len(wrap) == 0 {
+			return nil, nil
+		}
+		// Take all the following children that fit in the innermost wrapper
+		inner := wrap[len(wrap)-1].ContentMatch
+		var children []*model.Node
+		for ; i < frag.ChildCount(); i++ {
+			child, err = frag.Child(i)
+			if err != nil {
+				return nil, err
+			}
+			next := inner.MatchType(child.Type)
+			if next == nil {
+				break
+			}
+			children = append(children, child)
+			inner = next
+		}
+		var wrapped interface{} = children
+		for j := len(wrap) - 1; j >= 0; j-- {
+			node, err := wrap[j].CreateAndFill(nil, wrapped)
+			if node == nil {
+				return nil, err
+			}
+			wrapped = node
+		}
+		node := wrapped.(*model.Node)
+		result = append(result, node)
+		match = match.MatchType(node.Type)
+	}
+	return model.FragmentFrom(result)
+}
+
 // OpenNode wraps subsequent content in a node of the given type.
 func (state *MarkdownParseState) OpenNode(typ *model.NodeType, attrs map[string]interface{}) {
 	item := &StackItem{Type: typ, Attrs: attrs, Marks: model.NoMarks}
@@ -115,19 +200,34 @@ func (state *MarkdownParseState) CloseNode() (*model.Node, error) {
 	return state.AddNode(info.Type, info.Attrs, info.Content)
 }
 
+// ErrNotSupported is wrapped by the errors for the markdown nodes that a
+// parser doesn't support.
+var ErrNotSupported = errors.New("not supported by markdown parser")
+
 // ParseMarkdown parses a string as [CommonMark](http://commonmark.org/)
 // markup, and create a ProseMirror document as prescribed by this parser's
 // rules.
-func ParseMarkdown(parser parser.Parser, funcs NodeMapper, source []byte, schema *model.Schema) (*model.Node, error) {
+//
+// Options can be given to the parser:
+//
+//	softBreak:: ?string
+//	The text added for a soft line break (a newline in a paragraph that is
+//	not a hard break). Defaults to "\n", and " " can be used to join the
+//	lines with a space, like a browser renders them.
+//
+//	softBreakNode:: ?string
+//	The name of a node type (like "hard_break") to add for the soft line
+//	breaks, instead of the softBreak text.
+func ParseMarkdown(parser parser.Parser, funcs NodeMapper, source []byte, schema *model.Schema, options ...map[string]interface{}) (*model.Node, error) {
 	tree := parser.Parse(text.NewReader(source))
-	state := &MarkdownParseState{Source: source, Schema: schema}
+	state := newParseState(source, schema, options)
 	err := ast.Walk(tree, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
 		if fn, ok := funcs[node.Kind()]; ok {
 			if err := fn(state, node, entering); err != nil {
 				return ast.WalkStop, err
 			}
 		} else {
-			return ast.WalkStop, fmt.Errorf("Node kind %s not supported by markdown parser", node.Kind())
+			return ast.WalkStop, fmt.Errorf("Node kind %s %w", node.Kind(), ErrNotSupported)
 		}
 		return ast.WalkContinue, nil
 	})
@@ -140,6 +240,69 @@ func ParseMarkdown(parser parser.Parser, funcs NodeMapper, source []byte, schema
 	return state.Root, nil
 }
 
+// ParseMarkdownLenient is like ParseMarkdown, but it doesn't stop on the first
+// error. The fallback function is called for the node kinds that are not in
+// funcs, and for the nodes whose handler returns an error wrapping
+// ErrNotSupported when entering them (when fallback is nil, those nodes and
+// their children are skipped). If
+// a handler returns an error when entering a node, the node and its children
+// are skipped. If it returns an error when leaving a node, the nodes opened on
+// the stack since the node was entered are dropped with their content. The
+// errors are collected and returned as warnings, with the document built from
+// the rest of the markdown. The options are the same as for ParseMarkdown.
+func ParseMarkdownLenient(parser parser.Parser, funcs NodeMapper, source []byte, schema *model.Schema, fallback NodeMapperFunc, options ...map[string]interface{}) (*model.Node, []error) {
+	tree := parser.Parse(text.NewReader(source))
+	state := newParseState(source, schema, options)
+	var warnings []error
+	skipped := map[ast.Node]bool{}
+	depths := map[ast.Node]int{}
+	fallbacks := map[ast.Node]bool{}
+	_ = ast.Walk(tree, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering && skipped[node] {
+			delete(skipped, node)
+			return ast.WalkContinue, nil
+		}
+		fn, ok := funcs[node.Kind()]
+		if !ok || fallbacks[node] {
+			fn = fallback
+		}
+		if fn == nil {
+			warnings = append(warnings, fmt.Errorf("Node kind %s %w", node.Kind(), ErrNotSupported))
+			skipped[node] = true
+			return ast.WalkSkipChildren, nil
+		}
+		depth, opened := depths[node]
+		if entering {
+			depths[node] = len(state.Stack)
+		} else {
+			delete(depths, node)
+			delete(fallbacks, node)
+		}
+		err := fn(state, node, entering)
+		if err != nil && entering && ok && fallback != nil && errors.Is(err, ErrNotSupported) {
+			fallbacks[node] = true
+			err = fallback(state, node, entering)
+		}
+		if err != nil {
+			warnings = append(warnings, err)
+			if entering {
+				delete(depths, node)
+				delete(fallbacks, node)
+				skipped[node] = true
+				return ast.WalkSkipChildren, nil
+			}
+			if opened && len(state.Stack) > depth {
+				state.Stack = state.Stack[:depth]
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	if state.Root == nil {
+		return nil, append(warnings, errors.New("Cannot build prosemirror content"))
+	}
+	return state.Root, warnings
+}
+
 func GenericBlockHandler(nodeType string) NodeMapperFunc {
 	return func(state *MarkdownParseState, node ast.Node, entering bool) error {
 		if entering {
@@ -186,9 +349,20 @@ func WithoutTrailingNewline(node ast.Node, source []byte) string {
 	return strings.TrimSuffix(str, "\n")
 }
 
+// hardBreakType returns the node type for the hard breaks of the schema.
+func hardBreakType(schema *model.Schema) (*model.NodeType, error) {
+	typ, err := schema.NodeType("hardBreak")
+	if err != nil {
+		return schema.NodeType("hard_break")
+	}
+	return typ, nil
+}
+
+var brTagRegexp = regexp.MustCompile(`(?i)^<br\s*/?>$`)
+
 // DefaultNodeMapper is a parser parsing unextended
-// [CommonMark](http://commonmark.org/), without inline HTML, and producing a
-// document in the basic schema.
+// [CommonMark](http://commonmark.org/), without inline HTML except for the
+// <br> tags read as hard breaks, and producing a document in the basic schema.
 var DefaultNodeMapper = NodeMapper{
 	// Blocks
 	ast.KindDocument: func(state *MarkdownParseState, node ast.Node, entering bool) error {
@@ -239,10 +413,11 @@ var DefaultNodeMapper = NodeMapper{
 					return err
 				}
 			}
-			var attrs map[string]interface{}
+			// The tight attribute is ignored if the list node type
+			// doesn't have it.
+			attrs := map[string]interface{}{"tight": node.(*ast.List).IsTight}
 			if node.(*ast.List).IsOrdered() {
-				order := float64(node.(*ast.List).Start)
-				attrs = map[string]interface{}{"order": order}
+				attrs["order"] = float64(node.(*ast.List).Start)
 			}
 			state.OpenNode(typ, attrs)
 		} else {
@@ -300,9 +475,15 @@ var DefaultNodeMapper = NodeMapper{
 					return err
 				}
 			}
-			lang := node.Language(state.Source)
+			// The raw info string is kept in params, and its first word in
+			// language, as schemas can use one or the other.
+			params := ""
+			if node.Info != nil {
+				params = string(node.Info.Segment.Value(state.Source))
+			}
 			attrs := map[string]interface{}{
-				"language": string(lang),
+				"params":   params,
+				"language": string(node.Language(state.Source)),
 			}
 			state.OpenNode(typ, attrs)
 			state.AddText(WithoutTrailingNewline(node, state.Source))
@@ -324,12 +505,27 @@ var DefaultNodeMapper = NodeMapper{
 				state.AddText(string(content))
 			}
 			if n.HardLineBreak() {
-				typ, err := state.Schema.NodeType("hardBreak")
+				typ, err := hardBreakType(state.Schema)
 				if err != nil {
-					typ, err = state.Schema.NodeType("hard_break")
-					if err != nil {
-						return err
-					}
+					return err
+				}
+				if _, err := state.AddNode(typ, nil, nil); err != nil {
+					return err
+				}
+			} else if n.SoftLineBreak() {
+				if n.Parent() != nil && n.Parent().Kind() == ast.KindCodeSpan {
+					// CommonMark turns the line endings of code spans
+					// into spaces
+					state.AddText(" ")
+					return nil
+				}
+				if state.SoftBreakNode == "" {
+					state.AddText(state.SoftBreak)
+					return nil
+				}
+				typ, err := state.Schema.NodeType(state.SoftBreakNode)
+				if err != nil {
+					return err
 				}
 				if _, err := state.AddNode(typ, nil, nil); err != nil {
 					return err
@@ -338,6 +534,26 @@ var DefaultNodeMapper = NodeMapper{
 		}
 		return nil
 	},
+	ast.KindRawHTML: func(state *MarkdownParseState, node ast.Node, entering bool) error {
+		if !entering {
+			return nil
+		}
+		var raw []byte
+		segments := node.(*ast.RawHTML).Segments
+		for i := 0; i < segments.Len(); i++ {
+			segment := segments.At(i)
+			raw = append(raw, segment.Value(state.Source)...)
+		}
+		if !brTagRegexp.Match(raw) {
+			return fmt.Errorf("Node kind %s %w", node.Kind(), ErrNotSupported)
+		}
+		typ, err := hardBreakType(state.Schema)
+		if err != nil {
+			return err
+		}
+		_, err = state.AddNode(typ, nil, nil)
+		return err
+	},
 	ast.KindString: func(state *MarkdownParseState, node ast.Node, entering bool) error {
 		if entering {
 			content := node.(*ast.String).Value
@@ -351,12 +567,18 @@ var DefaultNodeMapper = NodeMapper{
 			return err
 		}
 		n := node.(*ast.AutoLink)
-		url := string(n.URL(state.Source))
-		attrs := map[string]interface{}{"href": url}
+		// The label is the text as written, while the URL can have a
+		// protocol added by the linkify extension (www.example.com).
+		label := string(n.Label(state.Source))
+		href := string(n.URL(state.Source))
+		if n.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(strings.ToLower(href), "mailto:") {
+			href = "mailto:" + href
+		}
+		attrs := map[string]interface{}{"href": href}
 		mark := typ.Create(attrs)
 		if entering {
 			state.OpenMark(mark)
-			state.AddText(url)
+			state.AddText(label)
 		} else {
 			state.CloseMark(mark)
 		}
@@ -403,24 +625,44 @@ var DefaultNodeMapper = NodeMapper{
 	},
 	ast.KindCodeSpan: GenericMarkHandler("code"),
 	ast.KindEmphasis: func(state *MarkdownParseState, node ast.Node, entering bool) error {
-		var typ *model.MarkType
-		var err error
-		if node.(*ast.Emphasis).Level == 2 {
-			typ, err = state.Schema.MarkType("strong")
-		} else {
-			typ, err = state.Schema.MarkType("em")
+		// goldmark nests a level 1 emphasis in a level 2 one for ***both***,
+		// but other parsers can give a single level 3 emphasis.
+		var names []string
+		switch node.(*ast.Emphasis).Level {
+		case 1:
+			names = []string{"em"}
+		case 2:
+			names = []string{"strong"}
+		default:
+			names = []string{"strong", "em"}
 		}
-		if err != nil {
-			return err
-		}
-		var attrs map[string]interface{}
-		mark := typ.Create(attrs)
-		if entering {
-			state.OpenMark(mark)
-		} else {
-			state.CloseMark(mark)
+		for _, name := range names {
+			typ, err := state.Schema.MarkType(name)
+			if err != nil {
+				return err
+			}
+			var attrs map[string]interface{}
+			mark := typ.Create(attrs)
+			if entering {
+				state.OpenMark(mark)
+			} else {
+				state.CloseMark(mark)
+			}
 		}
 		return nil
 	},
 	extensionast.KindStrikethrough: GenericMarkHandler("strike"),
 }
+
+// DefinitionListNodeMapper returns the node mappers for the definition lists
+// of the goldmark extension.DefinitionList extension. They build nodes of the
+// given types for the lists, the terms (with inline content), and the
+// descriptions (with block content). They can be added to a NodeMapper like
+// DefaultNodeMapper.
+func DefinitionListNodeMapper(list, term, description string) NodeMapper {
+	return NodeMapper{
+		extensionast.KindDefinitionList:        GenericBlockHandler(list),
+		extensionast.KindDefinitionTerm:        GenericBlockHandler(term),
+		extensionast.KindDefinitionDescription: GenericBlockHandler(description),
+	}
+}
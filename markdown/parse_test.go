@@ -1,6 +1,8 @@
 package markdown
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/cozy/prosemirror-go/model"
@@ -10,6 +12,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
 )
 
 var (
@@ -48,7 +54,7 @@ var (
 		"hr":  {"nodeType": "horizontal_rule"},
 		"li":  {"nodeType": "list_item"},
 		"ol":  {"nodeType": "ordered_list"},
-		"ol3": {"nodeType": "ordered_list", "order": float64(3)},
+		"ol3": {"nodeType": "ordered_list", "order": 3},
 		"ul":  {"nodeType": "bullet_list"},
 		"pre": {"nodeType": "code_block"},
 		"a":   {"markType": "link", "href": "foo"},
@@ -105,6 +111,20 @@ func TestMarkdown(t *testing.T) {
 	// parses a blockquote
 	same("> once\n\n> > twice",
 		doc(blockquote(p("once")), blockquote(blockquote(p("twice")))))
+	same("> a\n\n> > b\n\n> > > c",
+		doc(blockquote(p("a")), blockquote(blockquote(p("b"))), blockquote(blockquote(blockquote(p("c"))))))
+
+	// separates nested blockquotes and the paragraphs that follow them
+	same("> a\n>\n> > b\n> >\n> > > c\n>\n> d\n\ne",
+		doc(blockquote(p("a"), blockquote(p("b"), blockquote(p("c"))), p("d")), p("e")))
+	same("> > > a\n\nb",
+		doc(blockquote(blockquote(blockquote(p("a")))), p("b")))
+
+	// doesn't leave trailing spaces on the empty lines of nested code blocks
+	same("> > ```\n> > a\n> >\n> > b\n> > ```\n>\n> c",
+		doc(blockquote(blockquote(pre("a\n\nb")), p("c"))))
+	same("* x\n\n  ```\n  a\n\n  b\n  ```",
+		doc(ul(li(p("x"), pre("a\n\nb")))))
 
 	// FIXME bring back testing for preserving bullets and tight attrs
 	// when supported again
@@ -121,6 +141,16 @@ func TestMarkdown(t *testing.T) {
 	same("3. Foo\n\n4. Bar",
 		doc(ol3(li(p("Foo")), li(p("Bar")))))
 
+	// aligns the markers when the numbers get wider
+	same(" 98. a\n\n 99. b\n\n100. c\n\n101. d\n\n102. e",
+		doc(ol(map[string]interface{}{"order": float64(98)}, li(p("a")), li(p("b")), li(p("c")), li(p("d")), li(p("e")))))
+	same(" 9. a\n\n10. b\n\n    * c",
+		doc(ol(map[string]interface{}{"order": float64(9)}, li(p("a")), li(p("b"), ul(li(p("c")))))))
+
+	// aligns the markers of lists starting with a negative number
+	serialize(doc(ol(map[string]interface{}{"order": float64(-10)}, li(p("a")), li(p("b")))),
+		"-10. a\n\n -9. b")
+
 	// parses a code block
 	node, err := schema.Node("code_block", map[string]interface{}{"params": ""}, []interface{}{schema.Text("Here it is")})
 	assert.NoError(t, err)
@@ -131,11 +161,23 @@ func TestMarkdown(t *testing.T) {
 	// parse("Some code:\n\n    Here it is\n\nPara",
 	// 	doc(p("Some code:"), pre("Here it is"), p("Para")))
 
-	// TODO parses a fenced code block with info string
-	// node, err = schema.Node("code_block", map[string]interface{}{"params": "javascript"}, []interface{}{schema.Text("1")})
-	// assert.NoError(t, err)
-	// same("foo\n\n```javascript\n1\n```",
-	// 	doc(p("foo"), node))
+	// parses a fenced code block with info string
+	node, err = schema.Node("code_block", map[string]interface{}{"params": "javascript"}, []interface{}{schema.Text("1")})
+	assert.NoError(t, err)
+	same("foo\n\n```javascript\n1\n```",
+		doc(p("foo"), node))
+
+	// grows the fence for content with a line of four backticks
+	node, err = schema.Node("code_block", map[string]interface{}{"params": "md"}, []interface{}{schema.Text("````\ncode\n````")})
+	assert.NoError(t, err)
+	same("`````md\n````\ncode\n````\n`````",
+		doc(node))
+
+	// uses a tilde fence when the info string contains a backtick
+	node, err = schema.Node("code_block", map[string]interface{}{"params": "a`b"}, []interface{}{schema.Text("~~~\n```")})
+	assert.NoError(t, err)
+	same("~~~~a`b\n~~~\n```\n~~~~",
+		doc(node))
 
 	// parses inline marks
 	same("Hello. Some *em* text, some **strong** text, and some `code`",
@@ -165,6 +207,21 @@ func TestMarkdown(t *testing.T) {
 	serialize(doc(p("Three spaces: ", code("   "))),
 		"Three spaces: `   `")
 
+	// parses code mark starting and ending with backticks
+	same("`` `foo` ``", doc(p(code("`foo`"))))
+	same("``` ``foo` ```", doc(p(code("``foo`"))))
+
+	// parses code mark starting or ending with a backtick
+	same("`` `foo ``", doc(p(code("`foo"))))
+	same("`` foo` ``", doc(p(code("foo`"))))
+
+	// doesn't pad code mark with backticks only inside
+	same("``foo`bar``", doc(p(code("foo`bar"))))
+
+	// keeps the spaces around code mark content
+	same("`  foo  `", doc(p(code(" foo "))))
+	same("` foo`", doc(p(code(" foo"))))
+
 	// parses hard breaks
 	same("foo\\\nbar", doc(p("foo", br(), "bar")))
 	same("*foo\\\nbar*", doc(p(em("foo", br(), "bar"))))
@@ -258,6 +315,18 @@ func TestMarkdown(t *testing.T) {
 	same("<https://example.com/_file/#~anchor>",
 		doc(p(a(map[string]interface{}{"href": "https://example.com/_file/#~anchor"}, "https://example.com/_file/#~anchor"))))
 
+	// serializes mailto links as autolinks
+	same("Mail <mailto:foo@bar.com>",
+		doc(p("Mail ", a(map[string]interface{}{"href": "mailto:foo@bar.com"}, "mailto:foo@bar.com"))))
+
+	// parses email autolinks
+	same("Mail <foo@bar.com>",
+		doc(p("Mail ", a(map[string]interface{}{"href": "mailto:foo@bar.com"}, "foo@bar.com"))))
+
+	// doesn't use autolinks for text with a colon that is not a URL
+	serialize(doc(p(a(map[string]interface{}{"href": "1:2"}, "1:2"))), "[1:2](1:2)")
+	serialize(doc(p(a(map[string]interface{}{"href": "see: here"}, "see: here"))), "[see: here](see: here)")
+
 	// escape ! in front of links
 	serialize(doc(p("!", a("text"))),
 		"\\![text](foo)")
@@ -284,7 +353,303 @@ func TestMarkdown(t *testing.T) {
 		doc(schema.Node("code_block", attrs, content)).Node)
 	same(mdText, doc(schema.Node("code_block", attrs, content)))
 
+	// joins nested mixable marks
+	same("*one**two***",
+		doc(p(em("one", strong("two")))))
+	same("*one**two*** three",
+		doc(p(em("one", strong("two")), " three")))
+	same("**one*two*** three",
+		doc(p(strong("one", em("two")), " three")))
+
+	// parses triple emphasis as both em and strong
+	same("***both***",
+		doc(p(strong(em("both")))))
+	same("a ***both*** b",
+		doc(p("a ", strong(em("both")), " b")))
+	parse("___both___",
+		doc(p(strong(em("both")))))
+
 	// doesn't create an empty text
 	same("**foo**\\\nbar",
 		doc(p(strong("foo"), br, "bar")))
 }
+
+func TestParseSoftBreaks(t *testing.T) {
+	parse := func(text string, options ...map[string]interface{}) *model.Node {
+		actual, err := ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(text), schema, options...)
+		require.NoError(t, err)
+		return actual
+	}
+	check := func(actual *model.Node, expected builder.NodeWithTag) {
+		assert.True(t, actual.Eq(expected.Node), "%s != %s", actual, expected.Node)
+	}
+
+	// keeps the soft breaks as newlines by default
+	check(parse("a\nb"), doc(p("a\nb")))
+	check(parse("*a\nb* c\nd"), doc(p(em("a\nb"), " c\nd")))
+	assert.Equal(t, "a\nb", parse("a\nb").TextContent())
+
+	// can join the lines with a space
+	spaced := parse("a\nb", map[string]interface{}{"softBreak": " "})
+	check(spaced, doc(p("a b")))
+	assert.Equal(t, "a b", spaced.TextContent())
+
+	// can add a node for the soft breaks
+	check(parse("a\nb", map[string]interface{}{"softBreakNode": "hard_break"}), doc(p("a", br(), "b")))
+	_, err := ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte("a\nb"), schema,
+		map[string]interface{}{"softBreakNode": "unknown"})
+	assert.Error(t, err)
+
+	// turns the line endings of code spans into spaces
+	check(parse("`a\nb`"), doc(p(code("a b"))))
+
+	// doesn't change the hard breaks
+	check(parse("a\\\nb", map[string]interface{}{"softBreak": " "}), doc(p("a", br(), "b")))
+}
+
+func TestParseBrTags(t *testing.T) {
+	parse := func(source string) (*model.Node, error) {
+		return ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(source), schema)
+	}
+
+	// reads the <br> tags as hard breaks
+	for _, source := range []string{"a<br>b", "a<br/>b", "a<BR />b"} {
+		actual, err := parse(source)
+		if assert.NoError(t, err, source) {
+			expected := doc(p("a", br(), "b")).Node
+			assert.True(t, actual.Eq(expected), "%s != %s\n", actual.String(), expected.String())
+		}
+	}
+
+	// doesn't support the other tags
+	_, err := parse("a <span>b</span>")
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.EqualError(t, err, "Node kind RawHTML not supported by markdown parser")
+}
+
+func TestParseMarkdownLenient(t *testing.T) {
+	parser := goldmark.DefaultParser()
+	source := []byte("# Title\n\n<div>block</div>\n\nsome <span>html</span> here")
+
+	// fails on the first unsupported node
+	_, err := ParseMarkdown(parser, DefaultNodeMapper, source, schema)
+	assert.Error(t, err)
+
+	// skips the unsupported nodes without a fallback
+	actual, warnings := ParseMarkdownLenient(parser, DefaultNodeMapper, source, schema, nil)
+	expected := doc(h1("Title"), p("some html here")).Node
+	if assert.NotNil(t, actual) {
+		assert.True(t, actual.Eq(expected), "%s != %s\n", actual.String(), expected.String())
+	}
+	if assert.Len(t, warnings, 3) {
+		assert.EqualError(t, warnings[0], "Node kind HTMLBlock not supported by markdown parser")
+		assert.EqualError(t, warnings[1], "Node kind RawHTML not supported by markdown parser")
+		assert.EqualError(t, warnings[2], "Node kind RawHTML not supported by markdown parser")
+	}
+
+	// routes the unsupported nodes through the fallback
+	fallback := func(state *MarkdownParseState, node ast.Node, entering bool) error {
+		if raw, ok := node.(*ast.RawHTML); ok && entering {
+			for i := 0; i < raw.Segments.Len(); i++ {
+				segment := raw.Segments.At(i)
+				state.AddText(string(segment.Value(state.Source)))
+			}
+			return nil
+		}
+		if entering {
+			return fmt.Errorf("Cannot handle %s", node.Kind())
+		}
+		return nil
+	}
+	actual, warnings = ParseMarkdownLenient(parser, DefaultNodeMapper, source, schema, fallback)
+	expected = doc(h1("Title"), p("some <span>html</span> here")).Node
+	if assert.NotNil(t, actual) {
+		assert.True(t, actual.Eq(expected), "%s != %s\n", actual.String(), expected.String())
+	}
+	if assert.Len(t, warnings, 1) {
+		assert.EqualError(t, warnings[0], "Cannot handle HTMLBlock")
+	}
+
+	// drops the nodes left open by a handler failing on leave
+	mapper := NodeMapper{}
+	for kind, fn := range DefaultNodeMapper {
+		mapper[kind] = fn
+	}
+	mapper[ast.KindBlockquote] = func(state *MarkdownParseState, node ast.Node, entering bool) error {
+		if entering {
+			typ, err := state.Schema.NodeType("blockquote")
+			if err != nil {
+				return err
+			}
+			state.OpenNode(typ, nil)
+			return nil
+		}
+		return errors.New("Cannot close blockquote")
+	}
+	source = []byte("> quoted\n\nafter")
+	actual, warnings = ParseMarkdownLenient(parser, mapper, source, schema, nil)
+	expected = doc(p("after")).Node
+	if assert.NotNil(t, actual) {
+		assert.True(t, actual.Eq(expected), "%s != %s\n", actual.String(), expected.String())
+	}
+	if assert.Len(t, warnings, 1) {
+		assert.EqualError(t, warnings[0], "Cannot close blockquote")
+	}
+}
+
+func TestParseMarkdownWrapsContent(t *testing.T) {
+	// A mapper that adds the text of tight paragraphs directly into their
+	// parent, without a paragraph node
+	mapper := NodeMapper{}
+	for kind, fn := range DefaultNodeMapper {
+		mapper[kind] = fn
+	}
+	mapper[ast.KindTextBlock] = func(state *MarkdownParseState, node ast.Node, entering bool) error {
+		return nil
+	}
+
+	source := []byte("* one\n  * two\n    * three\n* four")
+	actual, err := ParseMarkdown(goldmark.DefaultParser(), mapper, source, schema)
+	require.NoError(t, err)
+	expected := doc(ul(
+		li(p("one"), ul(li(p("two"), ul(li(p("three")))))),
+		li(p("four")),
+	)).Node
+	assert.True(t, actual.Eq(expected), "%s != %s\n", actual.String(), expected.String())
+}
+
+func TestDefinitionList(t *testing.T) {
+	dlNodes := append([]*model.NodeSpec{}, schema.Spec.Nodes...)
+	dlNodes = append(dlNodes,
+		&model.NodeSpec{Key: "dl", Content: "(dt dd+)+", Group: "block"},
+		&model.NodeSpec{Key: "dt", Content: "inline*"},
+		&model.NodeSpec{Key: "dd", Content: "block+"},
+	)
+	dlSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: dlNodes, Marks: schema.Spec.Marks})
+	require.NoError(t, err)
+	out := builder.Builders(dlSchema, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+	dl := out["dl"].(builder.NodeBuilder)
+	dt := out["dt"].(builder.NodeBuilder)
+	dd := out["dd"].(builder.NodeBuilder)
+	em := out["em"].(builder.MarkBuilder)
+
+	mapper := NodeMapper{}
+	for kind, fn := range DefaultNodeMapper {
+		mapper[kind] = fn
+	}
+	for kind, fn := range DefinitionListNodeMapper("dl", "dt", "dd") {
+		mapper[kind] = fn
+	}
+	nodes := map[string]NodeSerializerFunc{}
+	for name, fn := range DefaultSerializer.Nodes {
+		nodes[name] = fn
+	}
+	for name, fn := range DefinitionListSerializers("dl", "dt", "dd") {
+		nodes[name] = fn
+	}
+	serializer := NewSerializer(nodes, DefaultSerializer.Marks)
+	parser := goldmark.New(goldmark.WithExtensions(extension.DefinitionList)).Parser()
+
+	same := func(text string, node builder.NodeWithTag) {
+		parsed, err := ParseMarkdown(parser, mapper, []byte(text), dlSchema)
+		if assert.NoError(t, err) {
+			assert.True(t, parsed.Eq(node.Node), "%s != %s", parsed, node)
+		}
+		assert.Equal(t, text, serializer.Serialize(node.Node))
+	}
+
+	// handles a simple definition list
+	same("Apple\n: A fruit",
+		doc(dl(dt("Apple"), dd(p("A fruit")))))
+
+	// handles multiple definitions per term, and several terms
+	same("Apple\n: A fruit\n: A company\n\nOrange\n: Another fruit",
+		doc(dl(dt("Apple"), dd(p("A fruit")), dd(p("A company")), dt("Orange"), dd(p("Another fruit")))))
+
+	// handles marks inside terms
+	same("Apple *pie*\n: A dessert",
+		doc(dl(dt("Apple ", em("pie")), dd(p("A dessert")))))
+
+	// handles several paragraphs in a description
+	same("Apple\n: A fruit\n\n  Usually red",
+		doc(dl(dt("Apple"), dd(p("A fruit"), p("Usually red")))))
+
+	// handles content around the list
+	same("Fruits:\n\nApple\n: A fruit\n\nThe end",
+		doc(p("Fruits:"), dl(dt("Apple"), dd(p("A fruit"))), p("The end")))
+}
+
+func TestMarkdownParseStateAddText(t *testing.T) {
+	paragraph, err := schema.NodeType("paragraph")
+	require.NoError(t, err)
+	state := &MarkdownParseState{Schema: schema}
+	state.OpenNode(paragraph, nil)
+
+	// ignores empty text
+	state.AddText("")
+	assert.Empty(t, state.Top().Content)
+
+	// merges adjacent text
+	state.AddText("foo")
+	state.AddText("")
+	state.AddText("bar")
+	if assert.Len(t, state.Top().Content, 1) {
+		assert.Equal(t, "foobar", *state.Top().Content[0].Text)
+	}
+}
+
+// astParser is a parser that returns a prebuilt AST.
+type astParser struct {
+	parser.Parser
+	root ast.Node
+}
+
+func (p astParser) Parse(reader text.Reader, opts ...parser.ParseOption) ast.Node {
+	return p.root
+}
+
+func TestParseEmphasisLevel3(t *testing.T) {
+	source := []byte("both")
+	para := ast.NewParagraph()
+	emphasis := ast.NewEmphasis(3)
+	segment := text.NewSegment(0, len(source))
+	emphasis.AppendChild(emphasis, ast.NewTextSegment(segment))
+	para.AppendChild(para, emphasis)
+	root := ast.NewDocument()
+	root.AppendChild(root, para)
+
+	actual, err := ParseMarkdown(astParser{goldmark.DefaultParser(), root}, DefaultNodeMapper, source, schema)
+	require.NoError(t, err)
+	expected := doc(p(strong(em("both")))).Node
+	assert.True(t, actual.Eq(expected), "%s != %s\n", actual.String(), expected.String())
+}
+
+func TestParseLinkify(t *testing.T) {
+	parser := goldmark.New(goldmark.WithExtensions(extension.Linkify)).Parser()
+	parse := func(text string, node builder.NodeWithTag) {
+		parsed, err := ParseMarkdown(parser, DefaultNodeMapper, []byte(text), schema)
+		if assert.NoError(t, err) {
+			assert.True(t, parsed.Eq(node.Node), "%s != %s", parsed, node)
+		}
+	}
+
+	// keeps the text as written for the links with an added protocol
+	parse("Visit www.example.com",
+		doc(p("Visit ", a(map[string]interface{}{"href": "http://www.example.com"}, "www.example.com"))))
+	assert.Equal(t, "[www.example.com](http://www.example.com)",
+		DefaultSerializer.Serialize(doc(p(a(map[string]interface{}{"href": "http://www.example.com"}, "www.example.com"))).Node))
+
+	// links bare URLs and emails
+	parse("See https://example.com",
+		doc(p("See ", a(map[string]interface{}{"href": "https://example.com"}, "https://example.com"))))
+	parse("Mail user@example.com",
+		doc(p("Mail ", a(map[string]interface{}{"href": "mailto:user@example.com"}, "user@example.com"))))
+
+	// email autolinks round-trip
+	parse("Mail <user@example.com>",
+		doc(p("Mail ", a(map[string]interface{}{"href": "mailto:user@example.com"}, "user@example.com"))))
+	assert.Equal(t, "Mail <user@example.com>",
+		DefaultSerializer.Serialize(doc(p("Mail ", a(map[string]interface{}{"href": "mailto:user@example.com"}, "user@example.com"))).Node))
+}
@@ -2,6 +2,8 @@ package markdown
 
 import (
 	"fmt"
+	"html"
+	"io"
 	"regexp"
 	"strings"
 	"unicode"
@@ -74,23 +76,47 @@ func (s *Serializer) Serialize(content *model.Node, options ...map[string]interf
 	}
 	state := NewSerializerState(s.Nodes, s.Marks, opts)
 	state.RenderContent(content)
-	return state.Out
+	return state.Out()
 }
 
-func getAttrInt(attrs map[string]interface{}, name string, defaultValue int) int {
-	value := defaultValue
-	switch v := attrs[name].(type) {
-	case int:
-		value = v
-	case float64:
-		value = int(v)
-	case int64:
-		value = int(v)
+// SerializeTo serializes the content of the given node to
+// [CommonMark](http://commonmark.org/), like Serialize, but the output is
+// written incrementally to w instead of being kept in memory.
+func (s *Serializer) SerializeTo(w io.Writer, content *model.Node, options ...map[string]interface{}) error {
+	var opts map[string]interface{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	state := NewSerializerState(s.Nodes, s.Marks, opts)
+	state.w = w
+	state.RenderContent(content)
+	return state.flush(0)
+}
+
+// SerializeSlice serializes the content of doc between from and to to
+// [CommonMark](http://commonmark.org/). The ancestors of the range are kept,
+// so that a range in the middle of a list renders the selected items in a list
+// of the same type. The nodes that are cut at the start and at the end of the
+// range are rendered like closed nodes, with only the part of their content
+// that is in the range. A range inside a textblock is rendered as a paragraph.
+func (s *Serializer) SerializeSlice(doc *model.Node, from, to int, options ...map[string]interface{}) (string, error) {
+	var opts map[string]interface{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	slice, err := doc.Slice(from, to, true)
+	if err != nil {
+		return "", err
 	}
-	return value
+	state := NewSerializerState(s.Nodes, s.Marks, opts)
+	state.RenderContent(doc.Copy(slice.Content))
+	return state.Out(), nil
 }
 
-var backticksRegexp = regexp.MustCompile("`{3,}")
+var (
+	backticksRegexp = regexp.MustCompile("`{3,}")
+	tildesRegexp    = regexp.MustCompile("~{3,}")
+)
 
 // DefaultSerializer is a serializer for the [basic schema](#schema).
 var DefaultSerializer = NewSerializer(map[string]NodeSerializerFunc{
@@ -98,16 +124,24 @@ var DefaultSerializer = NewSerializer(map[string]NodeSerializerFunc{
 		state.WrapBlock("> ", nil, node, func() { state.RenderContent(node) })
 	},
 	"code_block": func(state *SerializerState, node, _parent *model.Node, _index int) {
-		fence := "```"
+		params, ok := node.AttrString("params")
+		if !ok {
+			params, _ = node.AttrString("language")
+		}
+		// The info string of a backtick fence can't contain backticks, so a
+		// tilde fence is used in this case.
+		fence, fenceRegexp := "```", backticksRegexp
+		if strings.Contains(params, "`") {
+			fence, fenceRegexp = "~~~", tildesRegexp
+		}
 		content := node.TextContent()
-		matches := backticksRegexp.FindAllString(content, -1)
-		for _, backticks := range matches {
-			if len(backticks) >= len(fence) {
-				fence = backticks + "`"
+		matches := fenceRegexp.FindAllString(content, -1)
+		for _, chars := range matches {
+			if len(chars) >= len(fence) {
+				fence = chars + fence[:1]
 			}
 		}
 
-		params, _ := node.Attrs["params"].(string)
 		state.Write(fence + params + "\n")
 		state.Text(content, false)
 		// Add a newline to the current content before adding closing marker
@@ -116,14 +150,17 @@ var DefaultSerializer = NewSerializer(map[string]NodeSerializerFunc{
 		state.CloseBlock(node)
 	},
 	"heading": func(state *SerializerState, node, _parent *model.Node, _index int) {
-		level := getAttrInt(node.Attrs, "level", 1)
+		level, ok := node.AttrInt("level")
+		if !ok {
+			level = 1
+		}
 		state.Write(strings.Repeat("#", level) + " ")
 		state.RenderInline(node)
 		state.CloseBlock(node)
 	},
 	"horizontal_rule": func(state *SerializerState, node, _parent *model.Node, _index int) {
 		markup := "---"
-		if m, ok := node.Attrs["markup"].(string); ok {
+		if m, ok := node.AttrString("markup"); ok {
 			markup = m
 		}
 		state.Write(markup)
@@ -131,14 +168,22 @@ var DefaultSerializer = NewSerializer(map[string]NodeSerializerFunc{
 	},
 	"bullet_list": func(state *SerializerState, node, _parent *model.Node, _index int) {
 		bullet := "*"
-		if b, ok := node.Attrs["bullet"].(string); ok {
+		if b, ok := node.AttrString("bullet"); ok {
 			bullet = b
 		}
 		state.RenderList(node, "  ", func(_ int) string { return bullet + " " })
 	},
 	"ordered_list": func(state *SerializerState, node, _parent *model.Node, _index int) {
-		start := getAttrInt(node.Attrs, "order", 1)
+		start, ok := node.AttrInt("order")
+		if !ok {
+			start = 1
+		}
+		// The markers are right-aligned on the widest number, which is
+		// the last one, or the first one if it is negative.
 		maxW := len(fmt.Sprintf("%d", start+node.ChildCount()-1))
+		if w := len(fmt.Sprintf("%d", start)); w > maxW {
+			maxW = w
+		}
 		space := strings.Repeat(" ", maxW+2)
 		state.RenderList(node, space, func(i int) string {
 			nStr := fmt.Sprintf("%d", start+i)
@@ -153,12 +198,12 @@ var DefaultSerializer = NewSerializer(map[string]NodeSerializerFunc{
 		state.CloseBlock(node)
 	},
 	"image": func(state *SerializerState, node, _parent *model.Node, _index int) {
-		alt, _ := node.Attrs["alt"].(string)
-		src, _ := node.Attrs["src"].(string)
+		alt, _ := node.AttrString("alt")
+		src, _ := node.AttrString("src")
 		src = strings.ReplaceAll(src, "(", "\\(")
 		src = strings.ReplaceAll(src, ")", "\\)")
 		title := ""
-		if t, ok := node.Attrs["title"].(string); ok {
+		if t, ok := node.AttrString("title"); ok {
 			title = ` "` + strings.ReplaceAll(t, `"`, `\"`) + `"`
 		}
 		state.Write(fmt.Sprintf("![%s](%s)%s", state.Esc(alt), src, title))
@@ -172,6 +217,11 @@ var DefaultSerializer = NewSerializer(map[string]NodeSerializerFunc{
 				}
 			}
 		}
+		// A trailing backslash would be read as a literal one, but
+		// DefaultNodeMapper reads a <br> tag as a hard break.
+		if state.keepBreaks {
+			state.Write("<br>")
+		}
 	},
 	"text": func(state *SerializerState, node, _parent *model.Node, _index int) {
 		state.Text(*node.Text, !state.InAutoLink)
@@ -223,42 +273,102 @@ var DefaultSerializer = NewSerializer(map[string]NodeSerializerFunc{
 	},
 })
 
+// DefinitionListSerializers returns the node serializers for definition
+// lists, with the given node types for the lists, the terms and the
+// descriptions. They are written in the syntax of the goldmark
+// extension.DefinitionList extension: each term on its own line, followed
+// by its descriptions starting with ": ".
+func DefinitionListSerializers(list, term, description string) map[string]NodeSerializerFunc {
+	return map[string]NodeSerializerFunc{
+		list: func(state *SerializerState, node, _parent *model.Node, _index int) {
+			if state.Closed != nil && state.Closed.Type == node.Type {
+				state.flushClose(3)
+			}
+			node.ForEach(func(child *model.Node, _, i int) {
+				if i > 0 && child.Type.Name == description {
+					state.flushClose(1)
+				}
+				state.Render(child, node, i)
+			})
+		},
+		term: func(state *SerializerState, node, _parent *model.Node, _index int) {
+			state.RenderInline(node)
+			state.CloseBlock(node)
+		},
+		description: func(state *SerializerState, node, _parent *model.Node, _index int) {
+			first := ": "
+			state.WrapBlock("  ", &first, node, func() { state.RenderContent(node) })
+		},
+	}
+}
+
 func backticksFor(node *model.Node, side int) string {
 	length := 0
+	pad := false
 	if node.IsText() {
-		ticks := strings.FieldsFunc(*node.Text, func(r rune) bool { return r != '`' })
+		text := *node.Text
+		ticks := strings.FieldsFunc(text, func(r rune) bool { return r != '`' })
 		for _, t := range ticks {
 			if l := len(t); l > length {
 				length = l
 			}
 		}
+		pad = codeSpanNeedsPadding(text)
 	}
 	result := "`"
-	if length > 0 && side > 0 {
+	if pad && side > 0 {
 		result = " `"
 	}
 	for i := 0; i < length; i++ {
 		result += "`"
 	}
-	if length > 0 && side < 0 {
+	if pad && side < 0 {
 		result += " "
 	}
 	return result
 }
 
+// codeSpanNeedsPadding returns true if a space must be added on both sides of
+// the content of a code span. Per CommonMark, it is needed when the content
+// starts or ends with a backtick (which would be taken as a part of the
+// delimiter), and when it starts and ends with a space without being only
+// spaces (as one space is stripped on each side by the parser).
+func codeSpanNeedsPadding(text string) bool {
+	if text == "" {
+		return false
+	}
+	first, last := text[0], text[len(text)-1]
+	if first == '`' || last == '`' {
+		return true
+	}
+	return first == ' ' && last == ' ' && strings.Trim(text, " ") != ""
+}
+
+// absoluteURIRegexp matches the absolute URIs that CommonMark accepts in
+// autolinks: a scheme of 2 to 32 characters, followed by a colon and no
+// whitespace, control characters, < or >.
+var absoluteURIRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.\-]{1,31}:[^\x00-\x20<>\x7f]*$`)
+
+// emailRegexp matches the email addresses that CommonMark accepts in
+// autolinks.
+var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
 func isPlainURL(link *model.Mark, parent *model.Node, index int) bool {
 	if _, ok := link.Attrs["title"].(string); ok {
 		return false
 	}
 	href, _ := link.Attrs["href"].(string)
-	if !strings.Contains(href, ":") {
+	if !absoluteURIRegexp.MatchString(href) {
 		return false
 	}
 	content, err := parent.Child(index)
 	if err != nil {
 		return true
 	}
-	if !content.IsText() || *content.Text != href || content.Marks[len(content.Marks)-1] != link {
+	if !content.IsText() || content.Marks[len(content.Marks)-1] != link {
+		return false
+	}
+	if *content.Text != href && (href != "mailto:"+*content.Text || !emailRegexp.MatchString(*content.Text)) {
 		return false
 	}
 	if index == parent.ChildCount()-1 {
@@ -278,12 +388,80 @@ type SerializerState struct {
 	Nodes        map[string]NodeSerializerFunc
 	Marks        map[string]MarkSerializerSpec
 	Delim        string
-	Out          string
 	Closed       *model.Node
 	InAutoLink   bool
 	AtBlockStart bool
 	InTightList  bool
 	tightLists   bool
+	leafText     func(*model.Node) string
+	escapeTilde  bool
+	htmlEntities string
+	keepBreaks   bool
+
+	// out holds the output that has not been written to w yet (or the whole
+	// output when w is nil).
+	out     strings.Builder
+	w       io.Writer
+	written int
+	err     error
+}
+
+// flushThreshold is the size of the buffered output after which it is written
+// to the io.Writer, when there is one.
+const flushThreshold = 4096
+
+// Out returns the output that has been generated so far and not yet written
+// to an io.Writer. When the state writes to an io.Writer, like in
+// Serializer.SerializeTo, this is only the tail of the output that has not
+// been flushed. It replaces the Out field of earlier versions: the output
+// can't be changed directly anymore, only through the methods of the state.
+func (s *SerializerState) Out() string {
+	return s.out.String()
+}
+
+// emit appends str to the output, and writes the buffered output to the
+// io.Writer if it has grown large enough. The last bytes are kept in the
+// buffer, as they can still be inspected or modified. Nothing is appended
+// after the io.Writer has failed, as the output can't be completed anyway.
+func (s *SerializerState) emit(str string) {
+	if s.err != nil {
+		return
+	}
+	s.out.WriteString(str)
+	if s.w != nil && s.out.Len() > flushThreshold {
+		_ = s.flush(2)
+	}
+}
+
+// flush writes the buffered output to the io.Writer, except for the keep last
+// bytes. It returns the first error encountered while writing.
+func (s *SerializerState) flush(keep int) error {
+	if s.w == nil || s.err != nil {
+		return s.err
+	}
+	buffered := s.out.String()
+	if len(buffered) <= keep {
+		return nil
+	}
+	n, err := io.WriteString(s.w, buffered[:len(buffered)-keep])
+	s.written += n
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.out.Reset()
+	s.out.WriteString(buffered[len(buffered)-keep:])
+	return nil
+}
+
+// lastByte returns the byte at offset from the end of the buffered output (1
+// for the last byte), or 0 if there is none.
+func (s *SerializerState) lastByte(offset int) byte {
+	buffered := s.out.String()
+	if len(buffered) < offset {
+		return 0
+	}
+	return buffered[len(buffered)-offset]
 }
 
 // NewSerializerState is the constructor for NewSerializerState.
@@ -294,6 +472,29 @@ type SerializerState struct {
 //	Whether to render lists in a tight style. This can be overridden
 //	on a node level by specifying a tight attribute on the node.
 //	Defaults to false.
+//
+//	leafText:: ?func(*model.Node) string
+//	The text to output for the inline leaf nodes that have no serializer
+//	(like a mention). When not given, the LeafText or ToDebugString of the
+//	node spec is used, and if there is none, such nodes are skipped.
+//
+//	escapeTilde:: ?bool
+//	Whether to escape the ~ characters in the text, so that they are not
+//	taken for a strikethrough. Defaults to true when one of the marks is
+//	written with a ~ (like ~~ for a strike mark), and false otherwise.
+//
+//	htmlEntities:: ?string
+//	How to handle the HTML entities (like &amp;) and tags in the text. With
+//	"literal", the & and < characters that would start an entity or a tag
+//	are escaped, so that the text is read back as it is. With "decode", the
+//	entities are decoded to their characters before escaping. By default,
+//	they are written unchanged, and a markdown parser will interpret them.
+//
+//	keepTrailingHardBreaks:: ?bool
+//	Whether to write the hard breaks at the end of a textblock, which are
+//	dropped by default as they have no meaning in CommonMark. They are
+//	written as <br> tags, which DefaultNodeMapper parses back as hard
+//	breaks. Defaults to false.
 func NewSerializerState(
 	nodes map[string]NodeSerializerFunc,
 	marks map[string]MarkSerializerSpec,
@@ -303,17 +504,40 @@ func NewSerializerState(
 	if t, ok := options["tightLists"].(bool); ok {
 		tight = t
 	}
+	leafText, _ := options["leafText"].(func(*model.Node) string)
+	escapeTilde, ok := options["escapeTilde"].(bool)
+	if !ok {
+		escapeTilde = marksUseTilde(marks)
+	}
+	htmlEntities, _ := options["htmlEntities"].(string)
+	keepBreaks, _ := options["keepTrailingHardBreaks"].(bool)
 	return &SerializerState{
-		Nodes:       nodes,
-		Marks:       marks,
-		Delim:       "",
-		Out:         "",
-		Closed:      nil,
-		InTightList: false,
-		tightLists:  tight,
+		Nodes:        nodes,
+		Marks:        marks,
+		Delim:        "",
+		Closed:       nil,
+		InTightList:  false,
+		tightLists:   tight,
+		leafText:     leafText,
+		escapeTilde:  escapeTilde,
+		htmlEntities: htmlEntities,
+		keepBreaks:   keepBreaks,
 	}
 }
 
+// marksUseTilde returns true if one of the marks is opened or closed with a
+// string containing a ~.
+func marksUseTilde(marks map[string]MarkSerializerSpec) bool {
+	for _, spec := range marks {
+		for _, delim := range []interface{}{spec.Open, spec.Close} {
+			if str, ok := delim.(string); ok && strings.Contains(str, "~") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (s *SerializerState) flushClose(size ...int) {
 	if s.Closed == nil {
 		return
@@ -326,7 +550,7 @@ func (s *SerializerState) flushClose(size ...int) {
 	if siz > 1 {
 		delimMin := strings.TrimRightFunc(s.Delim, unicode.IsSpace)
 		for i := 1; i < siz; i++ {
-			s.Out += delimMin + "\n"
+			s.emit(delimMin + "\n")
 		}
 	}
 	s.Closed = nil
@@ -342,23 +566,39 @@ func (s *SerializerState) WrapBlock(delim string, firstDelim *string, node *mode
 		d = *firstDelim
 	}
 	s.Write(d)
+	size := s.written + s.out.Len()
 	s.Delim += delim
 	f()
 	s.Delim = old
+	// When the block is empty, don't leave the trailing whitespace of the
+	// delimiter at the end of the line.
+	if s.written+s.out.Len() == size {
+		s.trimTrailingSpace()
+	}
 	s.CloseBlock(node)
 }
 
+// trimTrailingSpace removes the spaces at the end of the buffered output.
+func (s *SerializerState) trimTrailingSpace() {
+	buffered := s.out.String()
+	trimmed := strings.TrimRight(buffered, " ")
+	if len(trimmed) != len(buffered) {
+		s.out.Reset()
+		s.out.WriteString(trimmed)
+	}
+}
+
 func (s *SerializerState) atBlank() bool {
-	if len(s.Out) == 0 {
+	if s.out.Len() == 0 && s.written == 0 {
 		return true
 	}
-	return s.Out[len(s.Out)-1] == '\n'
+	return s.lastByte(1) == '\n'
 }
 
 // EnsureNewLine ensures the current content ends with a newline.
 func (s *SerializerState) EnsureNewLine() {
 	if !s.atBlank() {
-		s.Out += "\n"
+		s.emit("\n")
 	}
 }
 
@@ -368,10 +608,10 @@ func (s *SerializerState) EnsureNewLine() {
 func (s *SerializerState) Write(content ...string) {
 	s.flushClose()
 	if s.Delim != "" && s.atBlank() {
-		s.Out += s.Delim
+		s.emit(s.Delim)
 	}
 	if len(content) > 0 {
-		s.Out += content[0]
+		s.emit(content[0])
 	}
 }
 
@@ -380,8 +620,6 @@ func (s *SerializerState) CloseBlock(node *model.Node) {
 	s.Closed = node
 }
 
-var textRegexp1 = regexp.MustCompile(`(^|[^\\])\!$`)
-
 // Text adds the given text to the document. When escape is not `false`, it
 // will be escaped.
 func (s *SerializerState) Text(text string, escape ...bool) {
@@ -391,26 +629,57 @@ func (s *SerializerState) Text(text string, escape ...bool) {
 		esc = escape[0]
 	}
 	for i, line := range lines {
+		if line == "" && i != len(lines)-1 {
+			// Don't leave trailing whitespace on the empty lines, like
+			// the ones of a code block in a blockquote
+			s.flushClose()
+			if s.Delim != "" && s.atBlank() {
+				s.emit(strings.TrimRightFunc(s.Delim, unicode.IsSpace))
+			}
+			s.emit("\n")
+			continue
+		}
 		s.Write()
 		// Escape exclamation marks in front of links
-		if !esc && len(line) > 0 && line[0] == '[' && textRegexp1.MatchString(s.Out) {
-			s.Out = s.Out[:len(s.Out)-1] + "\\!"
+		if !esc && len(line) > 0 && line[0] == '[' && s.endsWithUnescapedBang() {
+			buffered := s.out.String()
+			s.out.Reset()
+			s.out.WriteString(buffered[:len(buffered)-1])
+			s.emit("\\!")
 		}
 		if esc {
-			s.Out += s.Esc(line, s.AtBlockStart)
+			s.emit(s.Esc(line, s.AtBlockStart))
 		} else {
-			s.Out += line
+			s.emit(line)
 		}
 		if i != len(lines)-1 {
-			s.Out += "\n"
+			s.emit("\n")
 		}
 	}
 }
 
-// Render the given node as a block.
+func (s *SerializerState) endsWithUnescapedBang() bool {
+	if s.lastByte(1) != '!' {
+		return false
+	}
+	return s.lastByte(2) != '\\'
+}
+
+// Render the given node as a block. The inline leaf nodes without a serializer
+// are rendered with the leafText option, or the LeafText or ToDebugString of
+// their spec.
 func (s *SerializerState) Render(node, parent *model.Node, index int) {
 	if fn, ok := s.Nodes[node.Type.Name]; ok {
 		fn(s, node, parent, index)
+	} else if node.IsInline() && node.IsLeaf() {
+		// Don't silently drop the unknown inline leaves, like mentions
+		if s.leafText != nil {
+			s.Text(s.leafText(node))
+		} else if node.Type.Spec.LeafText != nil {
+			s.Text(node.Type.Spec.LeafText(node))
+		} else if node.Type.Spec.ToDebugString != nil {
+			s.Text(node.Type.Spec.ToDebugString(node))
+		}
 	}
 }
 
@@ -421,6 +690,22 @@ func (s *SerializerState) RenderContent(parent *model.Node) {
 	})
 }
 
+// markedAround looks for the first node that is not a hard break, after (dir
+// = 1) or before (dir = -1) the child at index of parent. It returns this node
+// if it and the hard breaks in between have the mark m, or nil.
+func markedAround(parent *model.Node, index, dir int, m *model.Mark) *model.Node {
+	for i := index + dir; i >= 0 && i < parent.ChildCount(); i += dir {
+		child, err := parent.Child(i)
+		if err != nil || !m.IsInSet(child.Marks) {
+			return nil
+		}
+		if child.Type.Name != "hard_break" {
+			return child
+		}
+	}
+	return nil
+}
+
 var inlineRegexp = regexp.MustCompile(`^(\s*)(.*?)(\s*)$`)
 
 // RenderInline renders the contents of `parent` as inline content.
@@ -435,25 +720,19 @@ func (s *SerializerState) RenderInline(parent *model.Node) {
 			marks = node.Marks
 		}
 
-		// Remove marks from `hard_break` that are the last node inside
-		// that mark to prevent parser edge cases with new lines just
-		// before closing marks.
+		// Remove marks from `hard_break` that are the first or last nodes
+		// inside that mark to prevent parser edge cases with new lines just
+		// after opening marks or before closing marks.
 		// (FIXME it'd be nice if we had a schema-agnostic way to
 		// identify nodes that serialize as hard breaks)
 		if node != nil && node.Type.Name == "hard_break" {
 			var filtered []*model.Mark
 			for _, m := range marks {
-				if index+1 == parent.ChildCount() {
+				next := markedAround(parent, index, 1, m)
+				if next == nil || (next.IsText() && strings.TrimSpace(*next.Text) == "") {
 					continue
 				}
-				next, err := parent.Child(index + 1)
-				if err != nil {
-					continue
-				}
-				if !m.IsInSet(next.Marks) {
-					continue
-				}
-				if !next.IsText() || strings.TrimSpace(*next.Text) != "" {
+				if markedAround(parent, index, -1, m) != nil {
 					filtered = append(filtered, m)
 				}
 			}
@@ -518,7 +797,8 @@ func (s *SerializerState) RenderInline(parent *model.Node) {
 		// in Markdown may be opened and closed in different order, so
 		// that order of the marks for the token matches the order in
 		// active.
-		for i, mark := range marks {
+		for i := 0; i < length; i++ {
+			mark := marks[i]
 			if !s.Marks[mark.Type.Name].Mixable {
 				break
 			}
@@ -527,6 +807,9 @@ func (s *SerializerState) RenderInline(parent *model.Node) {
 					break
 				}
 				if mark.Eq(other) {
+					if i == j {
+						break
+					}
 					mixed := make([]*model.Mark, 0, len(marks))
 					if i > j {
 						mixed = append(mixed, marks[:j]...)
@@ -535,9 +818,7 @@ func (s *SerializerState) RenderInline(parent *model.Node) {
 						mixed = append(mixed, marks[i+1:]...)
 					} else {
 						mixed = append(mixed, marks[:i]...)
-						if i != j {
-							mixed = append(mixed, marks[i+1:j]...)
-						}
+						mixed = append(mixed, marks[i+1:j]...)
 						mixed = append(mixed, mark)
 						mixed = append(mixed, marks[j:]...)
 					}
@@ -547,13 +828,35 @@ func (s *SerializerState) RenderInline(parent *model.Node) {
 			}
 		}
 
-		// Find the prefix of the mark set that didn't change
+		// Marks of the same type that don't exclude each other (like two
+		// comments with different ids) have no defined order in a mark set,
+		// so reorder them to match active, and keep them nested.
+		for i := 0; i < len(active) && i < length; i++ {
+			if marks[i].Eq(active[i]) || marks[i].Type != active[i].Type {
+				continue
+			}
+			for j := i + 1; j < length && marks[j].Type == active[i].Type; j++ {
+				if marks[j].Eq(active[i]) {
+					mixed := make([]*model.Mark, 0, len(marks))
+					mixed = append(mixed, marks[:i]...)
+					mixed = append(mixed, marks[j])
+					mixed = append(mixed, marks[i:j]...)
+					mixed = append(mixed, marks[j+1:]...)
+					marks = mixed
+					break
+				}
+			}
+		}
+
+		// Find the prefix of the mark set that didn't change. The marks
+		// that can't span several nodes are closed and opened again
+		// around each node.
 		min := len(marks)
 		if l := len(active); l < min {
 			min = l
 		}
 		keep := 0
-		for keep < min && marks[keep].Eq(active[keep]) {
+		for keep < min && marks[keep].Eq(active[keep]) && active[keep].Type.IsSpanning() {
 			keep++
 		}
 
@@ -604,7 +907,7 @@ func (s *SerializerState) RenderList(node *model.Node, delim string, firstDelim
 	}
 
 	isTight := s.tightLists
-	if t, ok := node.Attrs["tight"].(bool); ok {
+	if t, ok := node.AttrBool("tight"); ok {
 		isTight = t
 	}
 	prevTight := s.InTightList
@@ -620,10 +923,13 @@ func (s *SerializerState) RenderList(node *model.Node, delim string, firstDelim
 }
 
 var (
-	escRegexp1 = regexp.MustCompile("([`*\\\\~\\[\\]])")
+	escRegexp1 = regexp.MustCompile("([`*\\\\\\[\\]])")
 	escRegexp2 = regexp.MustCompile(`(\b_)|(_\b)`)
 	escRegexp3 = regexp.MustCompile(`^([#\-*+>])`)
 	escRegexp4 = regexp.MustCompile(`(\s*\d+)\.`)
+	escRegexp5 = regexp.MustCompile(`^(~~~)`)
+	escRegexp6 = regexp.MustCompile(`&(#[0-9]{1,7};|#[xX][0-9a-fA-F]{1,6};|[A-Za-z][A-Za-z0-9]*;)`)
+	escRegexp7 = regexp.MustCompile(`<([A-Za-z/!?])`)
 )
 
 // Esc escapes the given string so that it can safely appear in Markdown
@@ -634,11 +940,23 @@ func (s *SerializerState) Esc(str string, startOfLine ...bool) string {
 	if len(startOfLine) > 0 {
 		start = startOfLine[0]
 	}
+	if s.htmlEntities == "decode" {
+		str = html.UnescapeString(str)
+	}
 	str = escRegexp1.ReplaceAllString(str, "\\$1")
 	str = escRegexp2.ReplaceAllString(str, "\\_")
+	if s.escapeTilde {
+		str = strings.ReplaceAll(str, "~", "\\~")
+	}
+	if s.htmlEntities == "literal" {
+		str = escRegexp6.ReplaceAllString(str, "\\&$1")
+		str = escRegexp7.ReplaceAllString(str, "\\<$1")
+	}
 	if start {
 		str = escRegexp3.ReplaceAllString(str, "\\$1")
 		str = escRegexp4.ReplaceAllString(str, "$1\\.")
+		// A tilde fence would start a code block
+		str = escRegexp5.ReplaceAllString(str, "\\$1")
 	}
 	return str
 }
@@ -0,0 +1,441 @@
+package markdown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yuin/goldmark"
+)
+
+func largeDocument(paragraphs int) *model.Node {
+	var children []interface{}
+	for i := 0; i < paragraphs; i++ {
+		children = append(children,
+			h2("Section"),
+			p("Some ", em("emphasized"), " text, some ", strong("strong"), " text, and ", a("a link")),
+			ul(li(p("one")), li(p("two!"), p(a("three")))),
+			blockquote(p("a quote")),
+		)
+	}
+	return doc(children...).Node
+}
+
+func TestSerializeTo(t *testing.T) {
+	node := largeDocument(200)
+	expected := DefaultSerializer.Serialize(node)
+
+	var sb strings.Builder
+	err := DefaultSerializer.SerializeTo(&sb, node)
+	require.NoError(t, err)
+	assert.Equal(t, expected, sb.String())
+
+	// escape ! in front of links
+	sb.Reset()
+	err = DefaultSerializer.SerializeTo(&sb, doc(p("!", a("text"))).Node)
+	require.NoError(t, err)
+	assert.Equal(t, "\\![text](foo)", sb.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestSerializeToFailingWriter(t *testing.T) {
+	node := largeDocument(200)
+
+	// returns the error of the writer
+	err := DefaultSerializer.SerializeTo(failingWriter{}, node)
+	assert.EqualError(t, err, "write failed")
+
+	// stops buffering the output after the error
+	state := NewSerializerState(DefaultSerializer.Nodes, DefaultSerializer.Marks, nil)
+	state.w = failingWriter{}
+	state.RenderContent(node)
+	assert.EqualError(t, state.flush(0), "write failed")
+	assert.LessOrEqual(t, len(state.Out()), 2*flushThreshold)
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	node := largeDocument(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = DefaultSerializer.Serialize(node)
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+
+func BenchmarkSerializeTo(b *testing.B) {
+	node := largeDocument(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = DefaultSerializer.SerializeTo(discard{}, node)
+	}
+}
+
+func TestSerializeTightLists(t *testing.T) {
+	var nodes []*model.NodeSpec
+	for _, spec := range schema.Spec.Nodes {
+		cpy := *spec
+		switch cpy.Key {
+		case "bullet_list":
+			cpy.Attrs = map[string]*model.AttributeSpec{"tight": {Default: false}}
+		case "ordered_list":
+			cpy.Attrs = map[string]*model.AttributeSpec{"order": {Default: 1.0}, "tight": {Default: false}}
+		}
+		nodes = append(nodes, &cpy)
+	}
+	tightSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks})
+	require.NoError(t, err)
+	out := builder.Builders(tightSchema, map[string]builder.Spec{
+		"p":   {"nodeType": "paragraph"},
+		"li":  {"nodeType": "list_item"},
+		"tul": {"nodeType": "bullet_list", "tight": true},
+		"lul": {"nodeType": "bullet_list", "tight": false},
+		"tol": {"nodeType": "ordered_list", "tight": true},
+	})
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+	li := out["li"].(builder.NodeBuilder)
+	tul := out["tul"].(builder.NodeBuilder)
+	lul := out["lul"].(builder.NodeBuilder)
+	tol := out["tol"].(builder.NodeBuilder)
+	blockquote := out["blockquote"].(builder.NodeBuilder)
+
+	same := func(text string, node builder.NodeWithTag) {
+		assert.Equal(t, text, DefaultSerializer.Serialize(node.Node))
+		parsed, err := ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(text), tightSchema)
+		require.NoError(t, err)
+		assert.True(t, parsed.Eq(node.Node), "%s != %s\n", parsed, node)
+	}
+
+	// a loose list inside a tight list
+	same("* a\n  * b\n\n  * c\n* d",
+		doc(tul(li(p("a"), lul(li(p("b")), li(p("c")))), li(p("d")))))
+
+	// a tight list inside a loose list
+	same("* a\n\n  * b\n  * c\n\n* d",
+		doc(lul(li(p("a"), tul(li(p("b")), li(p("c")))), li(p("d")))))
+
+	// tight lists separated by a paragraph
+	same("* a\n* b\n\nx\n\n1. a\n2. b",
+		doc(tul(li(p("a")), li(p("b"))), p("x"), tol(li(p("a")), li(p("b")))))
+
+	// a tight list inside a blockquote
+	same("> 1. a\n> 2. b",
+		doc(blockquote(tol(li(p("a")), li(p("b"))))))
+
+	// a list item continuation inside a blockquote
+	same("> 1. a\n>    * b\n>    * c\n> 2. d",
+		doc(blockquote(tol(li(p("a"), tul(li(p("b")), li(p("c")))), li(p("d"))))))
+
+	// the tight attribute wins over the tightLists option
+	assert.Equal(t, "* a\n\n* b",
+		DefaultSerializer.Serialize(doc(lul(li(p("a")), li(p("b")))).Node, map[string]interface{}{"tightLists": true}))
+}
+
+func TestSerializeUnknownInlineLeaf(t *testing.T) {
+	nodes := append([]*model.NodeSpec{}, schema.Spec.Nodes...)
+	nodes = append(nodes,
+		&model.NodeSpec{
+			Key: "mention", Group: "inline", Inline: true, Atom: true,
+			Attrs:         map[string]*model.AttributeSpec{"name": {}},
+			ToDebugString: func(n *model.Node) string { return "@" + n.Attrs["name"].(string) },
+		},
+		&model.NodeSpec{Key: "emoji", Group: "inline", Inline: true, Atom: true},
+		&model.NodeSpec{
+			Key: "tag", Group: "inline", Inline: true, Atom: true,
+			Attrs:         map[string]*model.AttributeSpec{"name": {}},
+			LeafText:      func(n *model.Node) string { return "$" + n.Attrs["name"].(string) },
+			ToDebugString: func(n *model.Node) string { return "tag(" + n.Attrs["name"].(string) + ")" },
+		},
+	)
+	mentionSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks})
+	require.NoError(t, err)
+	out := builder.Builders(mentionSchema, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+	mention := out["mention"].(builder.NodeBuilder)
+	emoji := out["emoji"].(builder.NodeBuilder)
+
+	node := doc(p("Hi ", mention(map[string]interface{}{"name": "bob_b"}), "!", emoji)).Node
+
+	// uses the ToDebugString of the spec by default
+	assert.Equal(t, "Hi @bob_b!", DefaultSerializer.Serialize(node))
+
+	// uses the leafText option when given
+	leafText := func(n *model.Node) string {
+		if n.Type.Name == "mention" {
+			return "[" + n.Attrs["name"].(string) + "]"
+		}
+		return ":smile:"
+	}
+	assert.Equal(t, "Hi \\[bob_b\\]!:smile:",
+		DefaultSerializer.Serialize(node, map[string]interface{}{"leafText": leafText}))
+
+	// prefers the LeafText of the spec to its ToDebugString
+	tag := out["tag"].(builder.NodeBuilder)
+	assert.Equal(t, "See $go", DefaultSerializer.Serialize(doc(p("See ", tag(map[string]interface{}{"name": "go"}))).Node))
+}
+
+func TestSerializeNonExclusiveMarks(t *testing.T) {
+	marks := append([]*model.MarkSpec{}, schema.Spec.Marks...)
+	marks = append(marks, &model.MarkSpec{
+		Key:      "comment",
+		Attrs:    map[string]*model.AttributeSpec{"id": {}},
+		Excludes: &empty,
+	})
+	commentSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: schema.Spec.Nodes, Marks: marks})
+	require.NoError(t, err)
+	out := builder.Builders(commentSchema, map[string]builder.Spec{
+		"p":  {"nodeType": "paragraph"},
+		"c1": {"markType": "comment", "id": 1},
+		"c2": {"markType": "comment", "id": 2},
+	})
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+	c1 := out["c1"].(builder.MarkBuilder)
+	c2 := out["c2"].(builder.MarkBuilder)
+
+	serializerMarks := map[string]MarkSerializerSpec{}
+	for name, spec := range DefaultSerializer.Marks {
+		serializerMarks[name] = spec
+	}
+	serializerMarks["comment"] = MarkSerializerSpec{
+		Open: func(_ *SerializerState, mark *model.Mark, _ *model.Node, _ int) string {
+			return fmt.Sprintf(`<span data-id="%v">`, mark.Attrs["id"])
+		},
+		Close: "</span>",
+	}
+	serializer := NewSerializer(DefaultSerializer.Nodes, serializerMarks)
+
+	// nests marks of the same type with different attributes
+	assert.Equal(t, `a<span data-id="1">b<span data-id="2">c</span></span>d`,
+		serializer.Serialize(doc(p("a", c1("b", c2("c")), "d")).Node))
+	assert.Equal(t, `a<span data-id="2">b<span data-id="1">c</span>d</span>`,
+		serializer.Serialize(doc(p("a", c2("b", c1("c"), "d"))).Node))
+}
+
+func TestSerializeNonSpanningMarks(t *testing.T) {
+	outer := -1
+	check := func(spanning bool, expected string) {
+		marks := append([]*model.MarkSpec{}, schema.Spec.Marks...)
+		marks = append(marks, &model.MarkSpec{Key: "highlight", Rank: &outer, Spanning: &spanning})
+		highlightSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: schema.Spec.Nodes, Marks: marks})
+		require.NoError(t, err)
+		out := builder.Builders(highlightSchema, map[string]builder.Spec{
+			"p":  {"nodeType": "paragraph"},
+			"hl": {"markType": "highlight"},
+		})
+		doc := out["doc"].(builder.NodeBuilder)
+		p := out["p"].(builder.NodeBuilder)
+		em := out["em"].(builder.MarkBuilder)
+		hl := out["hl"].(builder.MarkBuilder)
+
+		serializerMarks := map[string]MarkSerializerSpec{}
+		for name, spec := range DefaultSerializer.Marks {
+			serializerMarks[name] = spec
+		}
+		serializerMarks["highlight"] = MarkSerializerSpec{Open: "==", Close: "=="}
+		serializer := NewSerializer(DefaultSerializer.Nodes, serializerMarks)
+		assert.Equal(t, expected, serializer.Serialize(doc(p(hl("a", em("b")), "c")).Node))
+	}
+
+	// keeps a spanning mark open over several nodes
+	check(true, "==a*b*==c")
+
+	// closes and opens again a non-spanning mark around each node
+	check(false, "==a====*b*==c")
+}
+
+func TestSerializeEmptyWrappedBlocks(t *testing.T) {
+	check := func(node builder.NodeWithTag, expected string) {
+		assert.Equal(t, expected, DefaultSerializer.Serialize(node.Node))
+		var sb strings.Builder
+		require.NoError(t, DefaultSerializer.SerializeTo(&sb, node.Node))
+		assert.Equal(t, expected, sb.String())
+	}
+
+	// doesn't add a trailing space to an empty blockquote
+	check(doc(blockquote(p())), ">")
+
+	// handles an empty blockquote between paragraphs
+	check(doc(p("a"), blockquote(p()), p("b")), "a\n\n>\n\nb")
+
+	// handles nested empty blockquotes
+	check(doc(blockquote(blockquote(p()))), "> >")
+
+	// handles empty list items
+	check(doc(ul(li(p()), li(p("b")))), "*\n\n* b")
+}
+
+func TestSerializeTilde(t *testing.T) {
+	marks := append([]*model.MarkSpec{}, schema.Spec.Marks...)
+	marks = append(marks, &model.MarkSpec{Key: "strike"})
+	strikeSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: schema.Spec.Nodes, Marks: marks})
+	require.NoError(t, err)
+	out := builder.Builders(strikeSchema, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+	strike := out["strike"].(builder.MarkBuilder)
+
+	serializerMarks := map[string]MarkSerializerSpec{}
+	for name, spec := range DefaultSerializer.Marks {
+		serializerMarks[name] = spec
+	}
+	serializerMarks["strike"] = MarkSerializerSpec{Open: "~~", Close: "~~", Mixable: true, ExpelEnclosingWhitespace: true}
+	strikeSerializer := NewSerializer(DefaultSerializer.Nodes, serializerMarks)
+
+	node := doc(p("about ~5 ~~items~~"), p("~~~ not a fence")).Node
+
+	// doesn't escape ~ without a strike mark
+	assert.Equal(t, "about ~5 ~~items~~\n\n\\~~~ not a fence", DefaultSerializer.Serialize(node))
+
+	// escapes ~ when the serializer has a strike mark
+	assert.Equal(t, "about \\~5 \\~\\~items\\~\\~\n\n\\~\\~\\~ not a fence", strikeSerializer.Serialize(node))
+	assert.Equal(t, "a ~~b\\~c~~", strikeSerializer.Serialize(doc(p("a ", strike("b~c"))).Node))
+
+	// can be forced with an option
+	assert.Equal(t, "about \\~5 \\~\\~items\\~\\~\n\n\\~\\~\\~ not a fence",
+		DefaultSerializer.Serialize(node, map[string]interface{}{"escapeTilde": true}))
+	assert.Equal(t, "about ~5 ~~items~~\n\n\\~~~ not a fence",
+		strikeSerializer.Serialize(node, map[string]interface{}{"escapeTilde": false}))
+}
+
+func TestSerializeHTMLEntities(t *testing.T) {
+	node := doc(p("Tom & Jerry &amp; co, 1 < 2 <div>")).Node
+	parse := func(text string) *model.Node {
+		parsed, err := ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(text), schema)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	// writes entities and tags unchanged by default
+	assert.Equal(t, "Tom & Jerry &amp; co, 1 < 2 <div>", DefaultSerializer.Serialize(node))
+
+	// escapes the entities and tags to keep them literal
+	literal := DefaultSerializer.Serialize(node, map[string]interface{}{"htmlEntities": "literal"})
+	assert.Equal(t, "Tom & Jerry \\&amp; co, 1 < 2 \\<div>", literal)
+	parsed := parse(literal)
+	assert.True(t, parsed.Eq(node), "%s != %s", parsed, node)
+
+	// decodes the entities before escaping
+	decoded := DefaultSerializer.Serialize(node, map[string]interface{}{"htmlEntities": "decode"})
+	assert.Equal(t, "Tom & Jerry & co, 1 < 2 <div>", decoded)
+	assert.Equal(t, "a \\* b", DefaultSerializer.Serialize(doc(p("a &ast; b")).Node,
+		map[string]interface{}{"htmlEntities": "decode"}))
+}
+
+func TestSerializeHardBreaksAtMarkBoundaries(t *testing.T) {
+	check := func(node *model.Node, markdown string, parsed *model.Node) {
+		out := DefaultSerializer.Serialize(node)
+		assert.Equal(t, markdown, out)
+		actual, err := ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(out), schema)
+		require.NoError(t, err)
+		assert.True(t, actual.Eq(parsed), "%s != %s", actual, parsed)
+	}
+
+	// keeps the breaks inside the marks
+	check(doc(p(em("foo", br(), "bar"))).Node, "*foo\\\nbar*", doc(p(em("foo", br(), "bar"))).Node)
+
+	// moves the breaks out of the marks at the closing edge
+	check(doc(p(em("foo", br()), "bar")).Node, "*foo*\\\nbar", doc(p(em("foo"), br(), "bar")).Node)
+	check(doc(p(em("foo", br(), br()), "bar")).Node, "*foo*\\\n\\\nbar", doc(p(em("foo"), br(), br(), "bar")).Node)
+	check(doc(p(strong("foo", br()), em("bar"))).Node, "**foo**\\\n*bar*", doc(p(strong("foo"), br(), em("bar"))).Node)
+
+	// moves the breaks out of the marks at the opening edge
+	check(doc(p("foo", em(br(), "bar"))).Node, "foo\\\n*bar*", doc(p("foo", br(), em("bar"))).Node)
+	check(doc(p("foo", em(br(), br(), "bar"))).Node, "foo\\\n\\\n*bar*", doc(p("foo", br(), br(), em("bar"))).Node)
+	check(doc(p(em(br(), "bar"))).Node, "\\\n*bar*", doc(p(br(), em("bar"))).Node)
+}
+
+func TestSerializeTrailingHardBreaks(t *testing.T) {
+	keep := map[string]interface{}{"keepTrailingHardBreaks": true}
+	node := doc(p("a", br, br), p("b", br, "c")).Node
+
+	// drops the trailing hard breaks by default
+	assert.Equal(t, "a\n\nb\\\nc", DefaultSerializer.Serialize(node))
+
+	// writes them as <br> tags with the option
+	out := DefaultSerializer.Serialize(node, keep)
+	assert.Equal(t, "a<br><br>\n\nb\\\nc", out)
+
+	// round-trips them
+	parsed, err := ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(out), schema)
+	require.NoError(t, err)
+	assert.True(t, parsed.Eq(node), "%s != %s", parsed, node)
+
+	// round-trips the trailing breaks of marked text
+	node = doc(p(em("a", br), br)).Node
+	out = DefaultSerializer.Serialize(node, keep)
+	parsed, err = ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(out), schema)
+	require.NoError(t, err)
+	expected := doc(p(em("a"), br, br)).Node
+	assert.True(t, parsed.Eq(expected), "%s != %s", parsed, expected)
+}
+
+func TestSerializeSlice(t *testing.T) {
+	node := doc(
+		p("intro"),
+		ul(li(p("one")), li(p("two")), li(p("three"), ol(li(p("nested"))))),
+		p("outro"),
+	).Node
+
+	serializeSlice := func(from, to int) string {
+		out, err := DefaultSerializer.SerializeSlice(node, from, to)
+		require.NoError(t, err)
+		return out
+	}
+
+	// a slice cut from the middle of a list renders the selected items
+	assert.Equal(t, "* ne\n\n* two\n\n* th", serializeSlice(11, 26))
+	// a slice that spans the end of a list
+	assert.Equal(t, "* three\n\n  1. nested\n\nou", serializeSlice(24, 47))
+	// a slice inside a nested list keeps the type of this list
+	assert.Equal(t, "* 1. nest", serializeSlice(33, 37))
+	// a slice inside a paragraph renders its inline content
+	assert.Equal(t, "tr", serializeSlice(3, 5))
+	assert.Equal(t, "", serializeSlice(3, 3))
+
+	// returns an error for an invalid range
+	_, err := DefaultSerializer.SerializeSlice(node, 3, 100)
+	assert.Error(t, err)
+}
+
+func TestOrderedListIntOrder(t *testing.T) {
+	orderedList, err := schema.NodeType("ordered_list")
+	require.NoError(t, err)
+	list, err := orderedList.CreateChecked(map[string]interface{}{"order": 3}, []*model.Node{li(p("a")).Node, li(p("b")).Node}, nil)
+	require.NoError(t, err)
+	node := doc(list).Node
+	assert.Equal(t, 3, list.Attrs["order"])
+	assert.True(t, node.Eq(doc(ol(map[string]interface{}{"order": float64(3)}, li(p("a")), li(p("b")))).Node))
+
+	// round-trips through JSON, where the order becomes a float64
+	data, err := json.Marshal(node.ToJSON())
+	require.NoError(t, err)
+	var raw map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &raw))
+	fromJSON, err := model.NodeFromJSON(schema, raw)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, fromJSON.FirstChild().Attrs["order"])
+	assert.True(t, fromJSON.Eq(node), "%s != %s", fromJSON, node)
+
+	// round-trips through markdown
+	text := DefaultSerializer.Serialize(node)
+	assert.Equal(t, "3. a\n\n4. b", text)
+	parsed, err := ParseMarkdown(goldmark.DefaultParser(), DefaultNodeMapper, []byte(text), schema)
+	require.NoError(t, err)
+	assert.True(t, parsed.Eq(node), "%s != %s", parsed, node)
+}
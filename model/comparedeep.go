@@ -0,0 +1,156 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// compareDeep is used to compare attributes of nodes and marks. Values
+// decoded from JSON and values built in Go can have different types for the
+// same data (float64 vs int, []interface{} vs []string, etc.), so numbers,
+// slices and maps are normalized before being compared.
+func compareDeep(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	switch va.Kind() {
+	case reflect.Slice, reflect.Array:
+		if vb.Kind() != reflect.Slice && vb.Kind() != reflect.Array {
+			return false
+		}
+		if va.Len() != vb.Len() {
+			return false
+		}
+		for i := 0; i < va.Len(); i++ {
+			if !compareDeep(va.Index(i).Interface(), vb.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if vb.Kind() != reflect.Map || va.Len() != vb.Len() {
+			return false
+		}
+		if va.Type().Key().Kind() != reflect.String || vb.Type().Key().Kind() != reflect.String {
+			return reflect.DeepEqual(a, b)
+		}
+		iter := va.MapRange()
+		for iter.Next() {
+			key := reflect.ValueOf(iter.Key().String()).Convert(vb.Type().Key())
+			other := vb.MapIndex(key)
+			if !other.IsValid() {
+				return false
+			}
+			if !compareDeep(iter.Value().Interface(), other.Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareAttrs compares two sets of attributes. A nil map and an empty map
+// are considered equal.
+func compareAttrs(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		other, ok := b[name]
+		if !ok || !compareDeep(value, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// withDefaultAttrs returns the given attributes, completed with the default
+// values of the attributes that are missing, so that they can be compared to
+// the attributes of a node.
+func withDefaultAttrs(spec map[string]*Attribute, attrs map[string]interface{}) map[string]interface{} {
+	var completed map[string]interface{}
+	for name, attr := range spec {
+		if _, ok := attrs[name]; ok || !attr.HasDefault || attr.isComputed() {
+			continue
+		}
+		if completed == nil {
+			completed = make(map[string]interface{}, len(spec))
+			for k, v := range attrs {
+				completed[k] = v
+			}
+		}
+		completed[name] = attr.Default
+	}
+	if completed == nil {
+		return attrs
+	}
+	return completed
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// copyAttrs returns a deep copy of the given attributes: the maps and slices
+// are copied recursively.
+func copyAttrs(attrs map[string]interface{}) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+	return copyDeep(attrs).(map[string]interface{})
+}
+
+func copyDeep(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		cpy := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			cpy[k] = copyDeep(val)
+		}
+		return cpy
+	case []interface{}:
+		cpy := make([]interface{}, len(v))
+		for i, val := range v {
+			cpy[i] = copyDeep(val)
+		}
+		return cpy
+	}
+	return value
+}
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 // ContentMatch represents a match state of a node type's content expression,
@@ -57,6 +58,18 @@ func (cm *ContentMatch) MatchType(typ *NodeType) *ContentMatch {
 	return nil
 }
 
+// MatchNode matches a node, like MatchType, but it also checks that the marks
+// of the node are allowed by the parent type, and returns nil if they are
+// not. The parent type is needed because a content match doesn't know which
+// node type it belongs to (it is shared by the types with the same content
+// expression).
+func (cm *ContentMatch) MatchNode(node *Node, parent *NodeType) *ContentMatch {
+	if !parent.AllowsMarks(node.Marks) {
+		return nil
+	}
+	return cm.MatchType(node.Type)
+}
+
 // MatchFragment tries to match a fragment. Returns the resulting match when
 // successful.
 //
@@ -111,6 +124,13 @@ func (cm *ContentMatch) FillBefore(after *Fragment, toEnd ...bool) *Fragment {
 	if len(toEnd) > 0 {
 		end = toEnd[0]
 	}
+	return cm.FillBeforePreferring(after, end, nil)
+}
+
+// FillBeforePreferring is like FillBefore, but when the content expression
+// allows a choice between several node types for the inserted nodes, the
+// prefer node type is tried first. prefer can be nil for no preference.
+func (cm *ContentMatch) FillBeforePreferring(after *Fragment, end bool, prefer *NodeType) *Fragment {
 	startIndex := 0
 	seen := []*ContentMatch{cm}
 
@@ -121,10 +141,15 @@ func (cm *ContentMatch) FillBefore(after *Fragment, toEnd ...bool) *Fragment {
 			created := make([]*Node, len(types))
 			for i, typ := range types {
 				var err error
-				created[i], err = typ.CreateAndFill()
+				created[i], err = typ.CreateAndFill(nil, nil, nil, prefer)
 				if err != nil {
 					panic(err)
 				}
+				if created[i] == nil {
+					// The content of this node can't be generated, try
+					// another path
+					return nil
+				}
 			}
 			frag, err := FragmentFrom(created)
 			if err != nil {
@@ -133,7 +158,7 @@ func (cm *ContentMatch) FillBefore(after *Fragment, toEnd ...bool) *Fragment {
 			return frag
 		}
 
-		for i := 0; i < len(match.next); i += 2 {
+		for _, i := range match.edgeOrder(prefer) {
 			typ := match.next[i].(*NodeType)
 			next := match.next[i+1].(*ContentMatch)
 			if !(typ.IsText() || typ.HasRequiredAttrs()) && indexOf(seen, next) == -1 {
@@ -153,6 +178,74 @@ func (cm *ContentMatch) FillBefore(after *Fragment, toEnd ...bool) *Fragment {
 	return search(cm, nil)
 }
 
+// edgeOrder returns the indexes in next of the node types, with the prefer
+// node type first if it is one of them.
+func (cm *ContentMatch) edgeOrder(prefer *NodeType) []int {
+	order := make([]int, 0, len(cm.next)/2)
+	for i := 0; i < len(cm.next); i += 2 {
+		if cm.next[i] == prefer {
+			order = append([]int{i}, order...)
+		} else {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// FindWrapping finds a set of wrapping node types that would allow a node of
+// the given type to appear at this position. The result may be empty (when it
+// fits directly) and will be nil when no such wrapping exists.
+func (cm *ContentMatch) FindWrapping(target *NodeType) []*NodeType {
+	wrapCacheMutex.Lock()
+	defer wrapCacheMutex.Unlock()
+	for i := 0; i < len(cm.wrapCache); i += 2 {
+		if cm.wrapCache[i] == target {
+			return cm.wrapCache[i+1].([]*NodeType)
+		}
+	}
+	computed := cm.computeWrapping(target)
+	cm.wrapCache = append(cm.wrapCache, target, computed)
+	return computed
+}
+
+func (cm *ContentMatch) computeWrapping(target *NodeType) []*NodeType {
+	type active struct {
+		match *ContentMatch
+		typ   *NodeType
+		via   *active
+	}
+	seen := map[string]bool{}
+	queue := []*active{{match: cm}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		match := current.match
+		if match.MatchType(target) != nil {
+			result := []*NodeType{}
+			for obj := current; obj.typ != nil; obj = obj.via {
+				result = append(result, obj.typ)
+			}
+			for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+				result[i], result[j] = result[j], result[i]
+			}
+			return result
+		}
+		for i := 0; i < len(match.next); i += 2 {
+			typ := match.next[i].(*NodeType)
+			next := match.next[i+1].(*ContentMatch)
+			if !typ.IsLeaf() && !typ.HasRequiredAttrs() && !seen[typ.Name] && (current.typ == nil || next.ValidEnd) {
+				queue = append(queue, &active{match: typ.ContentMatch, typ: typ, via: current})
+				seen[typ.Name] = true
+			}
+		}
+	}
+	return nil
+}
+
+// wrapCacheMutex protects the wrapCache of the content matches, as they are
+// shared by all the documents of a schema.
+var wrapCacheMutex sync.Mutex
+
 // EmptyContentMatch is an empty ContentMatch.
 var EmptyContentMatch = NewContentMatch(true)
 
@@ -314,7 +407,7 @@ func resolveName(stream *tokenStream, name string) ([]*NodeType, error) {
 		}
 	}
 	if len(result) == 0 {
-		return nil, stream.err("No node or type %q found", name)
+		return nil, stream.err("No node type or group %q found", name)
 	}
 	return result, nil
 }
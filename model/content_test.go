@@ -149,6 +149,24 @@ func TestContentMatchMatchType(t *testing.T) {
 	valid(t, "hard_break{2,}", "hard_break hard_break hard_break hard_break")
 	// rejects an open range with too few elements
 	invalid(t, "hard_break{2,}", "hard_break")
+
+	// accepts no elements for a zero count
+	valid(t, "hard_break{0}", "")
+	// rejects any element for a zero count
+	invalid(t, "hard_break{0}", "hard_break")
+	// ignores a zero count in a sequence
+	valid(t, "image hard_break{0} text*", "image text")
+	invalid(t, "image hard_break{0} text*", "image hard_break text")
+	// accepts no elements for a zero open range
+	valid(t, "hard_break{0,}", "")
+	// accepts many elements for a zero open range
+	valid(t, "hard_break{0,}", "hard_break hard_break hard_break")
+	// only matches appropriate elements to a zero open range
+	invalid(t, "hard_break{0,}", "hard_break image")
+	// accepts an element or none for a {0,1} range
+	valid(t, "hard_break{0,1}", "")
+	valid(t, "hard_break{0,1}", "hard_break")
+	invalid(t, "hard_break{0,1}", "hard_break hard_break")
 }
 
 func TestContentMatchFillBefore(t *testing.T) {
@@ -179,6 +197,15 @@ func TestContentMatchFillBefore(t *testing.T) {
 	// fails for a mismatched plus
 	fill(t, "hard_break+", p(), p(img), nil)
 
+	// accepts a zero count with no elements
+	fill(t, "hard_break{0}", p(), p(), p())
+
+	// fails for an element matched to a zero count
+	fill(t, "hard_break{0}", p(), p(br), nil)
+
+	// accepts a zero open range across the bound
+	fill(t, "hard_break{0,}", p(br), p(br), p())
+
 	// accepts asterisk with content on both sides
 	fill(t, "heading* paragraph*", doc(h1()), doc(p()), doc())
 
@@ -228,3 +255,93 @@ func TestContentMatchFillBefore(t *testing.T) {
 	// refuses to complete an overflown count across two bounds
 	fill3(t, "paragraph{2}", doc(p()), doc(p()), doc(p()), nil)
 }
+
+func TestContentMatchFindWrapping(t *testing.T) {
+	wrap := func(expr, target string, expected []string) {
+		typ, err := schema.NodeType(target)
+		assert.NoError(t, err)
+		found := get(t, expr).FindWrapping(typ)
+		if expected == nil {
+			assert.Nil(t, found)
+			return
+		}
+		names := []string{}
+		for _, nt := range found {
+			names = append(names, nt.Name)
+		}
+		assert.Equal(t, expected, names)
+	}
+
+	// returns an empty wrapping when the type fits directly
+	wrap("paragraph+", "paragraph", []string{})
+
+	// wraps text in a paragraph
+	wrap("block+", "text", []string{"paragraph"})
+
+	// wraps through several levels
+	wrap("bullet_list", "text", []string{"bullet_list", "list_item", "paragraph"})
+
+	// returns nil when no wrapping is possible
+	wrap("heading", "paragraph", nil)
+}
+
+func TestContentMatchFillBeforeRequiredAttrs(t *testing.T) {
+	s, err := NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "(embed | paragraph)+"},
+			{Key: "embed", Group: "block", Attrs: map[string]*AttributeSpec{"src": nil}},
+			{Key: "paragraph", Content: "text*", Group: "block"},
+			{Key: "text"},
+		},
+	})
+	assert.NoError(t, err)
+	docType, err := s.NodeType("doc")
+	assert.NoError(t, err)
+	embed, err := s.NodeType("embed")
+	assert.NoError(t, err)
+	assert.True(t, embed.HasRequiredAttrs())
+
+	// skips the node type with required attributes
+	filled := docType.ContentMatch.FillBefore(EmptyFragment, true)
+	if assert.NotNil(t, filled) {
+		assert.Equal(t, 1, filled.ChildCount())
+		assert.Equal(t, "paragraph", filled.FirstChild().Type.Name)
+	}
+	created, err := docType.CreateAndFill()
+	assert.NoError(t, err)
+	if assert.NotNil(t, created) {
+		assert.Equal(t, "paragraph", created.FirstChild().Type.Name)
+	}
+
+	// fails when no generatable node type can be used
+	_, err = NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "embed+"},
+			{Key: "embed", Attrs: map[string]*AttributeSpec{"src": nil}},
+			{Key: "text"},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestContentMatchMatchNode(t *testing.T) {
+	codeBlock, err := schema.NodeType("code_block")
+	assert.NoError(t, err)
+	paragraph, err := schema.NodeType("paragraph")
+	assert.NoError(t, err)
+
+	plain := schema.Text("foo")
+	emphasized := schema.Text("foo", []*Mark{em2})
+
+	// matches a node whose marks are allowed
+	assert.Equal(t, codeBlock.ContentMatch.MatchType(plain.Type), codeBlock.ContentMatch.MatchNode(plain, codeBlock))
+	assert.NotNil(t, paragraph.ContentMatch.MatchNode(emphasized, paragraph))
+
+	// fails to match a node with a disallowed mark
+	assert.NotNil(t, codeBlock.ContentMatch.MatchType(emphasized.Type))
+	assert.Nil(t, codeBlock.ContentMatch.MatchNode(emphasized, codeBlock))
+	assert.False(t, codeBlock.ValidContent(NewFragment([]*Node{emphasized})))
+
+	// fails to match a node of a disallowed type
+	assert.Nil(t, codeBlock.ContentMatch.MatchNode(img().Node, codeBlock))
+}
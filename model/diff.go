@@ -116,3 +116,27 @@ func findDiffEnd(a, b *Fragment, posA, posB int) *DiffEnd {
 		posB -= size
 	}
 }
+
+// DiffRange returns the smallest range that has changed between the two
+// documents: from is the start of the range in both documents, toA its end in
+// a, and toB its end in b. Replacing from-toA in a with the content from-toB
+// of b gives b. ok is false when the documents have the same content.
+func DiffRange(a, b *Node) (from, toA, toB int, ok bool) {
+	start := a.Content.FindDiffStart(b.Content)
+	if start == nil {
+		return 0, 0, 0, false
+	}
+	end := a.Content.FindDiffEnd(b.Content)
+	from, toA, toB = *start, end.A, end.B
+	// The start and end may overlap when some content is repeated (e.g.
+	// inserting "a" in "aa"): move the end so that it isn't before the start.
+	minEnd := toA
+	if toB < minEnd {
+		minEnd = toB
+	}
+	if minEnd < from {
+		toA += from - minEnd
+		toB += from - minEnd
+	}
+	return from, toA, toB, true
+}
@@ -3,6 +3,7 @@ package model_test
 import (
 	"testing"
 
+	"github.com/cozy/prosemirror-go/model"
 	"github.com/cozy/prosemirror-go/test/builder"
 	"github.com/stretchr/testify/assert"
 )
@@ -135,3 +136,37 @@ func TestFragmentFindDiffEnd(t *testing.T) {
 		doc(p("hey"), p("hello")),
 	)
 }
+
+func TestDiffRange(t *testing.T) {
+	rng := func(a, b builder.NodeWithTag, from, toA, toB int) {
+		f, ta, tb, ok := model.DiffRange(a.Node, b.Node)
+		if assert.True(t, ok) {
+			assert.Equal(t, []int{from, toA, toB}, []int{f, ta, tb})
+			sliceB, err := b.Slice(f, tb)
+			assert.NoError(t, err)
+			replaced, err := a.Replace(f, ta, sliceB)
+			if assert.NoError(t, err) {
+				assert.True(t, replaced.Eq(b.Node), "%s != %s", replaced, b)
+			}
+		}
+	}
+
+	// returns false for identical documents
+	_, _, _, ok := model.DiffRange(doc(p("a"), p("b")).Node, doc(p("a"), p("b")).Node)
+	assert.False(t, ok)
+
+	// finds a change in the middle paragraph
+	rng(doc(p("one"), p("two"), p("three")), doc(p("one"), p("tXo"), p("three")), 7, 8, 8)
+
+	// finds a replaced paragraph
+	rng(doc(p("one"), p("two"), p("three")), doc(p("one"), h1("two"), p("three")), 5, 10, 10)
+
+	// handles insertions with repeated content
+	rng(doc(p("aa")), doc(p("aaa")), 3, 3, 4)
+
+	// handles deletions with repeated content
+	rng(doc(p("aaa")), doc(p("aa")), 3, 4, 3)
+
+	// handles an inserted paragraph (the range starts after the shared "t")
+	rng(doc(p("one"), p("three")), doc(p("one"), p("two"), p("three")), 7, 7, 12)
+}
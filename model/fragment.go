@@ -1,8 +1,12 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 )
 
 // Fragment represents a node's collection of child nodes.
@@ -66,6 +70,27 @@ func (f *Fragment) NodesBetween(from, to int, fn NBCallback, nodeStart int, pare
 	return nil
 }
 
+// LeaveCallback is a type of the function called by Walk when it leaves a
+// node. It gets the same arguments as an NBCallback.
+type LeaveCallback func(*Node, int, *Node, int)
+
+// Walk invokes enter for all the descendant nodes of this fragment, and
+// leave after the content of each of those nodes has been walked. Doesn't
+// descend into a node when enter returns false, but leave is still called
+// for it, so that the calls to enter and leave are always balanced.
+func (f *Fragment) Walk(enter NBCallback, leave LeaveCallback, nodeStart int, parent *Node) {
+	pos := nodeStart
+	for i, child := range f.Content {
+		if enter(child, pos, parent, i) && child.Content.Size > 0 {
+			child.Content.Walk(enter, leave, pos+1, child)
+		}
+		if leave != nil {
+			leave(child, pos, parent, i)
+		}
+		pos += child.NodeSize()
+	}
+}
+
 // textBetween extracts the text between `from` and `to`. See the same method
 // on [`Node`](#model.Node.textBetween).
 func (f *Fragment) textBetween(from, to int, args ...string) string {
@@ -73,13 +98,32 @@ func (f *Fragment) textBetween(from, to int, args ...string) string {
 	if len(args) > 0 {
 		blockSeparator = args[0]
 	}
-	leafText := ""
+	var leafText func(*Node) string
 	if len(args) > 1 {
-		leafText = args[1]
+		leafText = func(*Node) string { return args[1] }
 	}
+	return f.TextBetweenFunc(from, to, blockSeparator, leafText)
+}
+
+// TextBetweenFunc extracts the text between `from` and `to`, like
+// Node.TextBetween, but the text of the non-text leaf nodes is given by the
+// leafText function (when it is not nil), which can use their attributes. For
+// example, a mention can be exported as "@" followed by the name of the
+// mentioned user. When leafText is nil, the LeafText of the node specs is
+// used.
+func (f *Fragment) TextBetweenFunc(from, to int, blockSeparator string, leafText func(*Node) string) string {
 	text := ""
-	separated := true
+	first := true
+	// The end of the preformatted block we are in, if any: its content is
+	// taken verbatim, without block separators.
+	preEnd := -1
 	f.NodesBetween(from, to, func(node *Node, pos int, _ *Node, _ int) bool {
+		inPre := pos < preEnd
+		startsPre := !inPre && node.IsBlock() && node.Type.Whitespace() == "pre"
+		if startsPre {
+			preEnd = pos + node.NodeSize()
+		}
+		nodeText := ""
 		if node.IsText() {
 			max := from
 			if pos > max {
@@ -91,15 +135,22 @@ func (f *Fragment) textBetween(from, to int, args ...string) string {
 			if stop > size {
 				stop = size
 			}
-			text += node.TextBetween(start, stop)
-			separated = blockSeparator == ""
-		} else if node.IsLeaf() && leafText != "" {
-			text += leafText
-			separated = blockSeparator == ""
-		} else if !separated && node.IsBlock() {
-			text += blockSeparator
-			separated = true
+			nodeText = node.TextBetween(start, stop)
+		} else if node.IsLeaf() && node.Type.Spec.LinebreakReplacement {
+			nodeText = "\n"
+		} else if node.IsLeaf() && leafText != nil {
+			nodeText = leafText(node)
+		} else if node.IsLeaf() && node.Type.Spec.LeafText != nil {
+			nodeText = node.Type.Spec.LeafText(node)
 		}
+		if node.IsBlock() && (node.IsLeaf() && nodeText != "" || node.IsTextblock() || startsPre) && blockSeparator != "" && !inPre {
+			if first {
+				first = false
+			} else {
+				text += blockSeparator
+			}
+		}
+		text += nodeText
 		return true
 	}, 0, nil)
 	return text
@@ -119,7 +170,7 @@ func (f *Fragment) Append(other *Fragment) *Fragment {
 	content := make([]*Node, len(f.Content))
 	copy(content, f.Content)
 	i := 0
-	if last.IsText() && last.SameMarkup(first) {
+	if canJoinText(last, first) {
 		content[len(content)-1] = last.WithText(*last.Text + *first.Text)
 		i = 1
 	}
@@ -127,6 +178,43 @@ func (f *Fragment) Append(other *Fragment) *Fragment {
 	return NewFragment(content, f.Size+other.Size)
 }
 
+// JoinFragments creates a fragment containing the combined content of the
+// given fragments. It gives the same result as chaining calls to Append, but
+// builds the content in a single pass.
+func JoinFragments(frags ...*Fragment) *Fragment {
+	count, size := 0, 0
+	var last *Fragment
+	nonEmpty := 0
+	for _, frag := range frags {
+		if frag.Size > 0 {
+			last = frag
+			nonEmpty++
+		}
+		count += len(frag.Content)
+		size += frag.Size
+	}
+	switch nonEmpty {
+	case 0:
+		return EmptyFragment
+	case 1:
+		return last
+	}
+	content := make([]*Node, 0, count)
+	for _, frag := range frags {
+		for i, child := range frag.Content {
+			if i == 0 && len(content) > 0 {
+				last := content[len(content)-1]
+				if canJoinText(last, child) {
+					content[len(content)-1] = last.WithText(*last.Text + *child.Text)
+					continue
+				}
+			}
+			content = append(content, child)
+		}
+	}
+	return NewFragment(content, size)
+}
+
 // Cut out the sub-fragment between the two given positions.
 func (f *Fragment) Cut(from int, to ...int) *Fragment {
 	t := f.Size
@@ -176,6 +264,57 @@ func (f *Fragment) Cut(from int, to ...int) *Fragment {
 	return NewFragment(result, size)
 }
 
+// ReplaceRange creates a new fragment in which the content between the given
+// positions (relative to the start of this fragment) is replaced by the given
+// fragment. Adjacent text nodes with the same marks are joined. Unlike
+// Node.Replace, the result is not checked against a schema, which makes it
+// usable on detached content.
+//
+// The content is inserted in the node around from, like a closed slice with
+// Node.Replace: when from and to are inside different nodes, those nodes are
+// joined, keeping the markup of the node around from. An error is returned
+// when from and to are not at the same depth.
+func (f *Fragment) ReplaceRange(from, to int, content *Fragment) (*Fragment, error) {
+	if from < 0 || to > f.Size || from > to {
+		return nil, fmt.Errorf("Invalid range %d-%d for %v", from, to, f)
+	}
+	return f.replaceRange(from, to, content)
+}
+
+func (f *Fragment) replaceRange(from, to int, content *Fragment) (*Fragment, error) {
+	fromIndex, fromStart, fromOpen := f.openChild(from)
+	toIndex, toStart, toOpen := f.openChild(to)
+	if !fromOpen && !toOpen {
+		return f.Cut(0, from).Append(content).Append(f.Cut(to)), nil
+	}
+	if !fromOpen || !toOpen {
+		return nil, fmt.Errorf("Positions %d and %d are not at the same depth in %v", from, to, f)
+	}
+	fromNode, toNode := f.Content[fromIndex], f.Content[toIndex]
+	inner := fromNode.Content
+	innerTo := to - toStart - 1
+	if toIndex != fromIndex {
+		innerTo += inner.Size
+		inner = inner.Append(toNode.Content)
+	}
+	replaced, err := inner.replaceRange(from-fromStart-1, innerTo, content)
+	if err != nil {
+		return nil, err
+	}
+	joined := NewFragment([]*Node{fromNode.Copy(replaced)})
+	return f.Cut(0, fromStart).Append(joined).Append(f.Cut(toStart + toNode.NodeSize())), nil
+}
+
+// openChild returns the index and start of the child that contains pos, when
+// pos is inside a non-text child and not at its boundaries.
+func (f *Fragment) openChild(pos int) (int, int, bool) {
+	index, start, err := f.findIndex(pos)
+	if err != nil || start == pos || f.Content[index].IsText() {
+		return 0, 0, false
+	}
+	return index, start, true
+}
+
 // ReplaceChild creates a new fragment in which the node at the given index is
 // replaced by the given node.
 func (f *Fragment) ReplaceChild(index int, node *Node) *Fragment {
@@ -307,6 +446,37 @@ func (f *Fragment) findIndex(pos int, round ...int) (index int, offset int, err
 	panic(errors.New("Unexpected state"))
 }
 
+// Digest returns a stable hash of the content of this fragment: the node
+// types, attributes, marks, and text. Two fragments with the same content have
+// the same digest, whatever the order of the keys in the attributes maps.
+func (f *Fragment) Digest() string {
+	h := sha256.New()
+	f.writeDigest(h)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (f *Fragment) writeDigest(h hash.Hash) {
+	h.Write([]byte{'['})
+	for _, child := range f.Content {
+		child.writeDigest(h)
+	}
+	h.Write([]byte{']'})
+}
+
+func writeDigestAttrs(h hash.Hash, attrs map[string]interface{}) {
+	if len(attrs) == 0 {
+		h.Write([]byte("{}"))
+		return
+	}
+	// encoding/json sorts the keys of maps, and numbers like 1 and 1.0 have
+	// the same encoding.
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%#v", attrs))
+	}
+	h.Write(data)
+}
+
 // String returns a debugging string that describes this fragment.
 func (f *Fragment) String() string {
 	return fmt.Sprintf("<%s>", f.toStringInner())
@@ -323,20 +493,25 @@ func (f *Fragment) toStringInner() string {
 	return str
 }
 
-// ToJSON creates a JSON-serializeable representation of this fragment.
-func (f *Fragment) ToJSON() interface{} {
+// ToJSON creates a JSON-serializeable representation of this fragment. See
+// Node.ToJSON for omitDefaults.
+func (f *Fragment) ToJSON(omitDefaults ...bool) interface{} {
 	if len(f.Content) == 0 {
 		return nil
 	}
 	var items []interface{}
 	for _, n := range f.Content {
-		items = append(items, n.ToJSON())
+		items = append(items, n.ToJSON(omitDefaults...))
 	}
 	return items
 }
 
 // FragmentFromJSON deserializes a fragment from its JSON representation.
 func FragmentFromJSON(schema *Schema, value interface{}) (*Fragment, error) {
+	return fragmentFromJSON(newJSONImport(schema, nil), value)
+}
+
+func fragmentFromJSON(imp *jsonImport, value interface{}) (*Fragment, error) {
 	if value == nil {
 		return EmptyFragment, nil
 	}
@@ -345,9 +520,12 @@ func FragmentFromJSON(schema *Schema, value interface{}) (*Fragment, error) {
 		return nil, errors.New("Invalid input for Fragment.fromJSON")
 	}
 	var nodes []*Node
+	if len(items) > 0 {
+		nodes = make([]*Node, 0, len(items))
+	}
 	for _, item := range items {
 		obj, _ := item.(map[string]interface{})
-		node, err := NodeFromJSON(schema, obj)
+		node, err := nodeFromJSON(imp, obj)
 		if err != nil {
 			return nil, err
 		}
@@ -356,6 +534,13 @@ func FragmentFromJSON(schema *Schema, value interface{}) (*Fragment, error) {
 	return NewFragment(nodes), nil
 }
 
+// canJoinText returns true if the two given nodes are text nodes that can be
+// joined in a single text node, i.e. they have the same marks (compared by
+// value, not by identity).
+func canJoinText(a, b *Node) bool {
+	return a.IsText() && b.IsText() && a.SameMarkup(b)
+}
+
 // FragmentFromArray builds a fragment from an array of nodes. Ensures that
 // adjacent text nodes with the same marks are joined together.
 func FragmentFromArray(array []*Node) *Fragment {
@@ -366,17 +551,18 @@ func FragmentFromArray(array []*Node) *Fragment {
 	size := 0
 	for i, node := range array {
 		size += node.NodeSize()
-		if i > 0 && node.IsText() && array[i-1].SameMarkup(node) {
-			if len(joined) == 0 {
-				joined = array[0:i]
+		if i > 0 && canJoinText(array[i-1], node) {
+			if joined == nil {
+				joined = make([]*Node, i)
+				copy(joined, array[:i])
 			}
 			was := joined[len(joined)-1].Text
 			joined[len(joined)-1] = node.WithText(*was + *node.Text)
-		} else if len(joined) > 0 {
+		} else if joined != nil {
 			joined = append(joined, node)
 		}
 	}
-	if len(joined) == 0 {
+	if joined == nil {
 		joined = array
 	}
 	return NewFragment(joined, size)
@@ -0,0 +1,262 @@
+package model_test
+
+import (
+	"testing"
+
+	. "github.com/cozy/prosemirror-go/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFragmentReplaceRange(t *testing.T) {
+	replace := func(frag *Fragment, from, to int, content *Fragment, expected *Fragment) {
+		actual, err := frag.ReplaceRange(from, to, content)
+		if assert.NoError(t, err) {
+			assert.True(t, actual.Eq(expected), "%s != %s\n", actual.String(), expected.String())
+			assert.Equal(t, expected.Size, actual.Size)
+		}
+	}
+
+	// splices text into the middle of a text node
+	replace(p("foobar").Content, 3, 3, p("xyz").Content,
+		p("fooxyzbar").Content)
+
+	// replaces a range of text
+	replace(p("foobar").Content, 1, 5, p("xyz").Content,
+		p("fxyzr").Content)
+
+	// joins adjacent text nodes with the same marks
+	replace(p("foo", img, "bar").Content, 3, 4, EmptyFragment,
+		p("foobar").Content)
+
+	// keeps text nodes with different marks apart
+	replace(p("foo", img, "bar").Content, 3, 4, p(em("x")).Content,
+		p("foo", em("x"), "bar").Content)
+
+	// replaces whole blocks
+	replace(doc(p("a"), p("b"), p("c")).Content, 3, 6, doc(h1("x"), hr).Content,
+		doc(p("a"), h1("x"), hr, p("c")).Content)
+
+	// inserts content inside a paragraph
+	replace(doc(p("ab"), p("cd")).Content, 2, 2, p("X").Content,
+		doc(p("aXb"), p("cd")).Content)
+
+	// joins the paragraphs around a deleted range
+	replace(doc(p("ab"), p("cd")).Content, 2, 6, EmptyFragment,
+		doc(p("ad")).Content)
+
+	// joins the paragraphs around the inserted content, with the markup of the first one
+	replace(doc(h1("ab"), p("cd"), p("ef")).Content, 2, 10, p(em("X")).Content,
+		doc(h1("a", em("X"), "f")).Content)
+
+	// joins nested nodes
+	replace(doc(blockquote(p("ab")), blockquote(p("cd"))).Content, 3, 9, EmptyFragment,
+		doc(blockquote(p("ad"))).Content)
+
+	// replaces a range inside a nested node
+	replace(doc(blockquote(p("ab"), p("cd"))).Content, 3, 7, EmptyFragment,
+		doc(blockquote(p("ad"))).Content)
+
+	// rejects invalid ranges
+	_, err := p("foo").Content.ReplaceRange(2, 10, EmptyFragment)
+	assert.Error(t, err)
+
+	// rejects positions at different depths
+	_, err = doc(p("ab"), p("cd")).Content.ReplaceRange(2, 4, EmptyFragment)
+	assert.Error(t, err)
+	_, err = doc(p("ab"), p("cd")).Content.ReplaceRange(0, 6, EmptyFragment)
+	assert.Error(t, err)
+}
+
+func TestFragmentDigest(t *testing.T) {
+	// is the same for documents built in different ways
+	built := doc(h1("foo"), p("bar", em("baz"), img)).Node
+	parsed, err := schema.NodeFromJSON([]byte(`{"type":"doc","content":[
+		{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"foo"}]},
+		{"type":"paragraph","content":[
+			{"type":"text","text":"bar"},
+			{"type":"text","text":"baz","marks":[{"type":"em"}]},
+			{"type":"image","attrs":{"title":null,"alt":null,"src":"img.png"}}
+		]}
+	]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, built.Digest(), parsed.Digest())
+	assert.Equal(t, built.Content.Digest(), parsed.Content.Digest())
+
+	// changes when an attribute changes
+	assert.NotEqual(t, built.Digest(), doc(h2("foo"), p("bar", em("baz"), img)).Digest())
+
+	// changes when a mark changes
+	assert.NotEqual(t, built.Digest(), doc(h1("foo"), p("bar", strong("baz"), img)).Digest())
+
+	// changes when the structure changes
+	assert.NotEqual(t, doc(p("a"), p("b")).Digest(), doc(p("ab")).Digest())
+}
+
+func TestFragmentCutAtLeafBoundaries(t *testing.T) {
+	cut := func(frag *Fragment, from, to int, expected *Fragment) {
+		actual := frag.Cut(from, to)
+		assert.True(t, actual.Eq(expected), "%d-%d: %s != %s\n", from, to, actual.String(), expected.String())
+		assert.Equal(t, expected.Size, actual.Size)
+	}
+
+	content := p(br, "x").Content
+
+	// keeps the hard break when cutting before it
+	cut(content, 0, 1, p(br).Content)
+	cut(content, 0, 2, p(br, "x").Content)
+
+	// drops the hard break when cutting after it
+	cut(content, 1, 2, p("x").Content)
+	cut(content, 2, 2, EmptyFragment)
+
+	// returns nothing for an empty range on a boundary
+	cut(content, 0, 0, EmptyFragment)
+	cut(content, 1, 1, EmptyFragment)
+
+	// handles leaf nodes between text nodes
+	content = p("ab", img, "cd").Content
+	cut(content, 1, 3, p("b", img).Content)
+	cut(content, 2, 3, p(img).Content)
+	cut(content, 3, 4, p("c").Content)
+	cut(content, 2, 2, EmptyFragment)
+
+	// handles leaf blocks
+	content = doc(p("a"), hr, p("b")).Content
+	cut(content, 3, 4, doc(hr).Content)
+	cut(content, 2, 4, doc(p(), hr).Content)
+	cut(content, 4, 5, doc(p()).Content)
+}
+
+func TestFragmentCutAtMarkBoundaries(t *testing.T) {
+	noEmptyText := func(frag *Fragment) {
+		frag.NodesBetween(0, frag.Size, func(node *Node, _ int, _ *Node, _ int) bool {
+			assert.False(t, node.IsText() && *node.Text == "", "empty text node in %s", frag)
+			return true
+		}, 0, nil)
+	}
+	cut := func(frag *Fragment, from, to int, expected *Fragment) {
+		actual := frag.Cut(from, to)
+		assert.True(t, actual.Eq(expected), "%d-%d: %s != %s\n", from, to, actual.String(), expected.String())
+		noEmptyText(actual)
+	}
+
+	// doesn't leave an empty marked text node
+	content := p(em("abc")).Content
+	cut(content, 3, 3, EmptyFragment)
+	cut(content, 0, 0, EmptyFragment)
+	cut(content, 1, 3, p(em("bc")).Content)
+	assert.True(t, p(em("abc")).Cut(3, 3).Eq(p().Node))
+
+	// cuts exactly at the edges of a mark
+	content = p("a", em("bc"), "d").Content
+	cut(content, 1, 3, p(em("bc")).Content)
+	cut(content, 0, 1, p("a").Content)
+	cut(content, 3, 4, p("d").Content)
+	cut(content, 1, 1, EmptyFragment)
+	cut(content, 3, 3, EmptyFragment)
+
+	// inside blocks
+	content = doc(p(em("abc")), p("d")).Content
+	cut(content, 4, 4, EmptyFragment)
+	cut(content, 4, 6, doc(p(), p()).Content)
+}
+
+func TestFragmentJoinsTextWithEqualMarks(t *testing.T) {
+	// The marks are created independently, so they are equal but are not
+	// the same objects.
+	text := func(str string) *Node {
+		link := schema.Mark("link", map[string]interface{}{"href": "foo"})
+		return schema.Text(str, []*Mark{schema.Mark("em"), link})
+	}
+	expected := FragmentFromArray([]*Node{text("foobar")})
+	joined := func(frag *Fragment) {
+		assert.Equal(t, 1, frag.ChildCount(), frag.String())
+		assert.True(t, frag.Eq(expected), "%s != %s", frag, expected)
+	}
+
+	// joins the boundary text nodes in Append
+	joined(FragmentFromArray([]*Node{text("foo")}).Append(FragmentFromArray([]*Node{text("bar")})))
+
+	// joins adjacent text nodes in FragmentFromArray
+	array := []*Node{text("fo"), text("o"), text("bar")}
+	joined(FragmentFromArray(array))
+	// without modifying the given array
+	assert.Equal(t, "fo", *array[0].Text)
+	assert.Equal(t, "o", *array[1].Text)
+
+	// joins text nodes when replacing content
+	para, err := schema.Node("paragraph", nil, []*Node{text("foo")})
+	assert.NoError(t, err)
+	replaced, err := para.Replace(3, 3, NewSlice(FragmentFromArray([]*Node{text("bar")}), 0, 0))
+	if assert.NoError(t, err) {
+		joined(replaced.Content)
+	}
+
+	// doesn't join text nodes with different marks
+	other := schema.Text("bar", []*Mark{schema.Mark("em")})
+	assert.Equal(t, 2, FragmentFromArray([]*Node{text("foo"), other}).ChildCount())
+	assert.Equal(t, 2, FragmentFromArray([]*Node{text("foo")}).Append(FragmentFromArray([]*Node{other})).ChildCount())
+}
+
+func TestJoinFragments(t *testing.T) {
+	frag := func(nodes ...*Node) *Fragment { return FragmentFromArray(nodes) }
+	foo, bar := schema.Text("foo"), schema.Text("bar")
+	emBar := schema.Text("bar", []*Mark{schema.Mark("em")})
+	para := p("x").Node
+
+	// returns the empty fragment when there is no content
+	assert.Equal(t, EmptyFragment, JoinFragments())
+	assert.Equal(t, EmptyFragment, JoinFragments(EmptyFragment, EmptyFragment))
+
+	// returns the only non-empty fragment
+	single := frag(foo)
+	assert.Same(t, single, JoinFragments(EmptyFragment, single, EmptyFragment))
+
+	// gives the same result as chained calls to Append
+	parts := []*Fragment{frag(foo), frag(bar, para), EmptyFragment, frag(emBar), frag(bar), frag(para, foo)}
+	chained := EmptyFragment
+	for _, part := range parts {
+		chained = chained.Append(part)
+	}
+	joined := JoinFragments(parts...)
+	assert.True(t, joined.Eq(chained), "%s != %s", joined, chained)
+	assert.Equal(t, chained.Size, joined.Size)
+	assert.Equal(t, 6, joined.ChildCount())
+	assert.Equal(t, "foobar", *joined.FirstChild().Text)
+
+	// merges the text nodes across several fragments
+	joined = JoinFragments(frag(foo), frag(bar), frag(foo))
+	assert.Equal(t, 1, joined.ChildCount())
+	assert.Equal(t, "foobarfoo", *joined.FirstChild().Text)
+
+	// doesn't modify the given fragments
+	assert.Equal(t, 2, parts[1].ChildCount())
+	assert.Equal(t, "foo", *parts[0].FirstChild().Text)
+}
+
+func smallFragments(n int) []*Fragment {
+	frags := make([]*Fragment, n)
+	for i := range frags {
+		frags[i] = FragmentFromArray([]*Node{p("foo").Node, p("bar").Node})
+	}
+	return frags
+}
+
+func BenchmarkJoinFragments(b *testing.B) {
+	frags := smallFragments(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		JoinFragments(frags...)
+	}
+}
+
+func BenchmarkFragmentAppendChain(b *testing.B) {
+	frags := smallFragments(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		joined := EmptyFragment
+		for _, frag := range frags {
+			joined = joined.Append(frag)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -69,3 +70,75 @@ func TestJSONNode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, result)
 }
+
+const batchDocJSON = `{"type": "doc", "content": [
+	{"type": "heading", "attrs": {"level": 2}, "content": [{"type": "text", "text": "Title"}]},
+	{"type": "paragraph", "content": [
+		{"type": "text", "text": "Some "},
+		{"type": "text", "marks": [{"type": "em"}], "text": "emphasized"},
+		{"type": "text", "text": " and "},
+		{"type": "text", "marks": [{"type": "link", "attrs": {"href": "https://example.com"}}, {"type": "strong"}], "text": "linked"},
+		{"type": "text", "text": " text."}
+	]},
+	{"type": "bullet_list", "content": [
+		{"type": "list_item", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "one"}]}]},
+		{"type": "list_item", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "two"}]}]}
+	]}
+]}`
+
+func batchDocs(n int) []map[string]interface{} {
+	raws := make([]map[string]interface{}, n)
+	for i := range raws {
+		if err := json.Unmarshal([]byte(batchDocJSON), &raws[i]); err != nil {
+			panic(err)
+		}
+	}
+	return raws
+}
+
+func TestSchemaNodesFromJSON(t *testing.T) {
+	raws := batchDocs(3)
+	nodes, err := builder.Schema.NodesFromJSON(raws)
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 3)
+	for i, node := range nodes {
+		expected, err := model.NodeFromJSON(builder.Schema, raws[i])
+		assert.NoError(t, err)
+		assert.True(t, node.Eq(expected), "%s != %s", node, expected)
+	}
+
+	raws[1]["content"].([]interface{})[0].(map[string]interface{})["type"] = "title"
+	_, err = builder.Schema.NodesFromJSON(raws)
+	assert.EqualError(t, err, "Invalid node #1: Unknown node type: title")
+
+	raws = batchDocs(1)
+	paragraph := raws[0]["content"].([]interface{})[1].(map[string]interface{})
+	paragraph["content"].([]interface{})[1].(map[string]interface{})["marks"] = []interface{}{map[string]interface{}{"type": "underline"}}
+	_, err = builder.Schema.NodesFromJSON(raws)
+	assert.EqualError(t, err, "Invalid node #0: There is no mark underline in this schema")
+}
+
+func BenchmarkNodeFromJSONLoop(b *testing.B) {
+	raws := batchDocs(100)
+	b.ResetTimer()
+	nodes := make([]*model.Node, len(raws))
+	for i := 0; i < b.N; i++ {
+		for j, raw := range raws {
+			node, err := model.NodeFromJSON(builder.Schema, raw)
+			if err != nil {
+				b.Fatal(err)
+			}
+			nodes[j] = node
+		}
+	}
+}
+
+func BenchmarkSchemaNodesFromJSON(b *testing.B) {
+	raws := batchDocs(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := builder.Schema.NodesFromJSON(raws); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
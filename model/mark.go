@@ -2,7 +2,6 @@ package model
 
 import (
 	"fmt"
-	"reflect"
 	"sort"
 )
 
@@ -27,6 +26,12 @@ func NewMark(typ *MarkType, attrs map[string]interface{}) *Mark {
 // mark are present, those are replaced by this one.
 func (m *Mark) AddToSet(set []*Mark) []*Mark {
 	var cpy []*Mark
+	// startCopy allocates the new set once, with room for this mark, and
+	// copies the marks before i into it.
+	startCopy := func(i int) {
+		cpy = make([]*Mark, i, len(set)+1)
+		copy(cpy, set[:i])
+	}
 	placed := false
 	for i, other := range set {
 		if m.Eq(other) {
@@ -34,16 +39,14 @@ func (m *Mark) AddToSet(set []*Mark) []*Mark {
 		}
 		if m.Type.Excludes(other.Type) {
 			if cpy == nil {
-				cpy = make([]*Mark, i)
-				copy(cpy, set[:i])
+				startCopy(i)
 			}
 		} else if other.Type.Excludes(m.Type) {
 			return set
 		} else {
-			if !placed && other.Type.Rank > m.Type.Rank {
+			if !placed && m.Type.sortsBefore(other.Type) {
 				if cpy == nil {
-					cpy = make([]*Mark, i)
-					copy(cpy, set[:i])
+					startCopy(i)
 				}
 				cpy = append(cpy, m)
 				placed = true
@@ -54,8 +57,7 @@ func (m *Mark) AddToSet(set []*Mark) []*Mark {
 		}
 	}
 	if cpy == nil {
-		cpy = make([]*Mark, len(set))
-		copy(cpy, set)
+		startCopy(len(set))
 	}
 	if !placed {
 		cpy = append(cpy, m)
@@ -95,10 +97,7 @@ func (m *Mark) Eq(other *Mark) bool {
 	if m.Type != other.Type {
 		return false
 	}
-	if len(m.Attrs) != len(other.Attrs) {
-		return false
-	}
-	return reflect.DeepEqual(m.Attrs, other.Attrs)
+	return compareAttrs(m.Attrs, other.Attrs)
 }
 
 // ToJSON converts this mark to a JSON-serializeable representation.
@@ -158,8 +157,8 @@ func MarkSetFrom(marks ...interface{}) []*Mark {
 	if marks, ok := marks[0].([]*Mark); ok {
 		set := make([]*Mark, len(marks))
 		copy(set, marks)
-		sort.Slice(set, func(i, j int) bool {
-			return set[i].Type.Rank < set[j].Type.Rank
+		sort.SliceStable(set, func(i, j int) bool {
+			return set[i].Type.sortsBefore(set[j].Type)
 		})
 		return set
 	}
@@ -1,6 +1,7 @@
 package model_test
 
 import (
+	"fmt"
 	"testing"
 
 	. "github.com/cozy/prosemirror-go/model"
@@ -41,6 +42,35 @@ func TestMarkEq(t *testing.T) {
 
 	// considers links with different titles to differ
 	assert.False(t, link("http://foo").Eq(link("http://foo", "B")))
+
+	customSchema, err := NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "paragraph+"},
+			{Key: "paragraph", Content: "text*"},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{
+			{Key: "comment", Attrs: map[string]*AttributeSpec{"ids": {}, "meta": {Default: nil}}},
+		},
+	})
+	assert.NoError(t, err)
+	fromJSON, err := customSchema.MarkFromJSON([]byte(`{"type":"comment","attrs":{"ids":[1,2,3],"meta":{"size":4,"tags":["a"]}}}`))
+	assert.NoError(t, err)
+
+	// considers array attributes from JSON and from Go to be the same
+	fromGo := customSchema.Mark("comment", map[string]interface{}{
+		"ids":  []int{1, 2, 3},
+		"meta": map[string]interface{}{"size": 4, "tags": []string{"a"}},
+	})
+	assert.True(t, fromJSON.Eq(fromGo))
+	assert.True(t, fromGo.Eq(fromJSON))
+
+	// considers arrays with different values to differ
+	other := customSchema.Mark("comment", map[string]interface{}{
+		"ids":  []int{1, 2},
+		"meta": map[string]interface{}{"size": 4, "tags": []string{"a"}},
+	})
+	assert.False(t, fromJSON.Eq(other))
 }
 
 func TestMarkAddToSet(t *testing.T) {
@@ -161,6 +191,67 @@ func TestMarkAddToSet(t *testing.T) {
 	))
 }
 
+func TestMarkAddToSetAllocations(t *testing.T) {
+	em, strong, code := schema.Mark("em"), schema.Mark("strong"), schema.Mark("code")
+	set := []*Mark{em, strong}
+
+	// returns the set itself for a mark that is already present
+	again := schema.Mark("strong")
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { again.AddToSet(set) }))
+	added := again.AddToSet(set)
+	assert.Equal(t, &set[0], &added[0])
+
+	// allocates the new set only once
+	assert.Equal(t, 1.0, testing.AllocsPerRun(10, func() { code.AddToSet(set) }))
+	assert.Equal(t, []*Mark{em, strong, code}, code.AddToSet(set))
+}
+
+func TestMarkExcludesGroup(t *testing.T) {
+	style := "style"
+	customSchema, err := NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "paragraph+"},
+			{Key: "paragraph", Content: "text*"},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{
+			{Key: "bold", Group: "style"},
+			{Key: "italic", Group: "other style"},
+			{Key: "underline", Group: "style decoration"},
+			{Key: "comment", Attrs: idAttrs, Excludes: &empty},
+			{Key: "plain", Excludes: &style},
+		},
+	})
+	assert.NoError(t, err)
+	custom := make(map[string]*Mark)
+	for _, mt := range customSchema.Marks {
+		if mt.Name == "comment" {
+			custom[mt.Name] = mt.Create(map[string]interface{}{"id": 1})
+		} else {
+			custom[mt.Name] = mt.Create(nil)
+		}
+	}
+	plainType := custom["plain"].Type
+
+	// excludes every member of the group, including marks in several groups
+	for _, name := range []string{"bold", "italic", "underline"} {
+		assert.True(t, plainType.Excludes(custom[name].Type), name)
+	}
+	assert.False(t, plainType.Excludes(custom["comment"].Type))
+
+	// removes all the members of the group when added
+	assert.True(t, SameMarkSet(
+		custom["plain"].AddToSet([]*Mark{custom["bold"], custom["italic"], custom["underline"], custom["comment"]}),
+		[]*Mark{custom["comment"], custom["plain"]},
+	))
+
+	// can't add a member of the group to a set containing the excluding mark
+	assert.True(t, SameMarkSet(
+		custom["italic"].AddToSet([]*Mark{custom["plain"]}),
+		[]*Mark{custom["plain"]},
+	))
+}
+
 func TestMarkRemoveFromSet(t *testing.T) {
 	// is a no-op for the empty set
 	assert.True(t, SameMarkSet(em2.RemoveFromSet([]*Mark{}), []*Mark{}))
@@ -282,3 +373,62 @@ func TestMarkResolvedPos(t *testing.T) {
 		assert.True(t, SameMarkSet(resolved.Marks(), []*Mark{}))
 	}
 }
+
+func TestMarkTypeRemoveFromSet(t *testing.T) {
+	linkType, err := schema.MarkType("link")
+	assert.NoError(t, err)
+
+	// removes all the marks of the type, whatever their attributes
+	set := []*Mark{em2, link("http://foo"), link("http://bar", "B"), code2}
+	assert.True(t, SameMarkSet(linkType.RemoveFromSet(set), []*Mark{em2, code2}))
+
+	// doesn't modify the original set
+	assert.Len(t, set, 4)
+
+	// returns the same set when there is no mark of the type
+	set = []*Mark{em2, strong2}
+	assert.True(t, SameMarkSet(linkType.RemoveFromSet(set), set))
+
+	// returns an empty set when all the marks are removed
+	assert.Empty(t, linkType.RemoveFromSet([]*Mark{link("http://foo")}))
+}
+
+func BenchmarkMarkAddToSet(b *testing.B) {
+	none := ""
+	var specs []*MarkSpec
+	for i := 0; i < 32; i++ {
+		specs = append(specs, &MarkSpec{Key: fmt.Sprintf("m%d", i), Excludes: &none})
+	}
+	s, err := NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{{Key: "doc", Content: "text*"}, {Key: "text"}},
+		Marks: specs,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	marks := make([]*Mark, len(specs))
+	for i := range marks {
+		marks[i] = s.Mark(fmt.Sprintf("m%d", i))
+	}
+
+	for _, size := range []int{2, 8, 30} {
+		// Every other mark is in the set, and the last one is not
+		var set []*Mark
+		for i := 0; i < size; i += 2 {
+			set = append(set, marks[i])
+		}
+		cases := map[string]*Mark{
+			"end":     marks[31],
+			"middle":  marks[size/2+1-(size/2)%2],
+			"present": set[len(set)-1],
+		}
+		for name, mark := range cases {
+			b.Run(fmt.Sprintf("%s-%d", name, size), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					mark.AddToSet(set)
+				}
+			})
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"reflect"
+	"hash"
+	"strconv"
 	"unicode/utf16"
 )
 
@@ -44,7 +47,7 @@ func NewNode(typ *NodeType, attrs map[string]interface{}, content *Fragment, mar
 // plus two (the start and end token).
 func (n *Node) NodeSize() int {
 	if n.IsText() {
-		return len(asCodeUnits(*n.Text))
+		return codeUnitsLen(*n.Text)
 	}
 	if n.IsLeaf() {
 		return 1
@@ -91,12 +94,84 @@ func (n *Node) NodesBetween(from, to int, fn NBCallback, startPos ...int) {
 	n.Content.NodesBetween(from, to, fn, s, n)
 }
 
+// NodeWithPos is a node with its position in a document.
+type NodeWithPos struct {
+	Node *Node
+	Pos  int
+}
+
+// FindAll returns the descendant nodes of the given type, in document order,
+// with their positions relative to the start of this node's content. The
+// position is the one before the node, so NodeAt(pos) gives the node back.
+func (n *Node) FindAll(typeName string) []NodeWithPos {
+	var found []NodeWithPos
+	n.Content.NodesBetween(0, n.Content.Size, func(node *Node, pos int, _ *Node, _ int) bool {
+		if node.Type.Name == typeName {
+			found = append(found, NodeWithPos{Node: node, Pos: pos})
+		}
+		return true
+	}, 0, n)
+	return found
+}
+
+// Walk calls enter for each descendant node, and leave when exiting the
+// node, after its content has been walked. Unlike NodesBetween, each node
+// gets a matching leave event, which can be useful to build SAX-like
+// exporters.
+func (n *Node) Walk(enter NBCallback, leave LeaveCallback) {
+	n.Content.Walk(enter, leave, 0, n)
+}
+
+// ForEachMark calls fn for each contiguous range of inline content that has
+// a given mark, with the absolute positions of the start and the end of the
+// range. Adjacent inline nodes sharing an equal mark are reported as a single
+// range. The ranges are reported in the order in which they end.
+func (n *Node) ForEachMark(fn func(mark *Mark, from, to int)) {
+	type markRange struct {
+		mark     *Mark
+		from, to int
+	}
+	var open []markRange
+	n.Content.NodesBetween(0, n.Content.Size, func(node *Node, pos int, _ *Node, _ int) bool {
+		if !node.IsInline() {
+			return true
+		}
+		kept := open[:0]
+		for _, r := range open {
+			if r.to == pos && r.mark.IsInSet(node.Marks) {
+				kept = append(kept, r)
+			} else {
+				fn(r.mark, r.from, r.to)
+			}
+		}
+		open = kept
+		end := pos + node.NodeSize()
+	Marks:
+		for _, mark := range node.Marks {
+			for i := range open {
+				if open[i].mark.Eq(mark) {
+					open[i].to = end
+					continue Marks
+				}
+			}
+			open = append(open, markRange{mark, pos, end})
+		}
+		return true
+	}, 0, n)
+	for _, r := range open {
+		fn(r.mark, r.from, r.to)
+	}
+}
+
 // TextContent concatenates all the text nodes found in this fragment and its
 // children.
 func (n *Node) TextContent() string {
 	if n.IsText() {
 		return *n.Text
 	}
+	if n.IsLeaf() && n.Type.Spec.LeafText != nil {
+		return n.Type.Spec.LeafText(n)
+	}
 	return n.TextBetween(0, n.Content.Size, "")
 }
 
@@ -112,6 +187,16 @@ func (n *Node) TextBetween(from, to int, args ...string) string {
 	return n.Content.textBetween(from, to, args...)
 }
 
+// TextBetweenFunc gets all text between positions from and to, like
+// TextBetween, but it calls leafText to get the text of each non-text leaf
+// node. See Fragment.TextBetweenFunc.
+func (n *Node) TextBetweenFunc(from, to int, blockSeparator string, leafText func(*Node) string) string {
+	if n.IsText() {
+		return n.TextBetween(from, to)
+	}
+	return n.Content.TextBetweenFunc(from, to, blockSeparator, leafText)
+}
+
 // UnitCodeAt returns the UTF-16 unit code at the given position. It is a
 // function that does not exist in the original prosemirror in JS, as it
 // is only useful in Go to emulate the behavior of strings in JavaScript.
@@ -162,10 +247,11 @@ func (n *Node) HasMarkup(typ *NodeType, args ...interface{}) bool {
 	var attrs map[string]interface{}
 	if len(args) > 0 {
 		attrs, _ = args[0].(map[string]interface{})
+		attrs = withDefaultAttrs(typ.Attrs, attrs)
 	} else {
 		attrs = typ.DefaultAttrs
 	}
-	if !reflect.DeepEqual(n.Attrs, attrs) {
+	if !compareAttrs(n.Attrs, attrs) {
 		return false
 	}
 	marks := NoMarks
@@ -175,6 +261,33 @@ func (n *Node) HasMarkup(typ *NodeType, args ...interface{}) bool {
 	return SameMarkSet(n.Marks, marks)
 }
 
+// AttrString returns the value of the given attribute if it is a string.
+func (n *Node) AttrString(name string) (string, bool) {
+	value, ok := n.Attrs[name].(string)
+	return value, ok
+}
+
+// AttrInt returns the value of the given attribute as an int. It accepts int,
+// int64, and float64 values, the latter being what is found for the numbers
+// in the attributes of a document parsed from JSON.
+func (n *Node) AttrInt(name string) (int, bool) {
+	switch v := n.Attrs[name].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// AttrBool returns the value of the given attribute if it is a bool.
+func (n *Node) AttrBool(name string) (bool, bool) {
+	value, ok := n.Attrs[name].(bool)
+	return value, ok
+}
+
 // Copy creates a new node with the same markup as this node, containing the
 // given content (or empty, if no content is given).
 func (n *Node) Copy(content ...*Fragment) *Node {
@@ -185,6 +298,38 @@ func (n *Node) Copy(content ...*Fragment) *Node {
 	return NewNode(n.Type, n.Attrs, c, n.Marks)
 }
 
+// WithContent is like Copy, but it checks the given content against the
+// content restrictions of the node type, and returns an InvalidContentError
+// if it doesn't match.
+func (n *Node) WithContent(content *Fragment) (*Node, error) {
+	if content == nil {
+		content = EmptyFragment
+	}
+	if !n.Type.ValidContent(content) {
+		return nil, &InvalidContentError{Type: n.Type}
+	}
+	return n.Copy(content), nil
+}
+
+// DeepCopy creates a copy of this node with its own attrs map and its own
+// marks (with their own attrs too). The content is shared, as fragments are
+// immutable. The nodes of a document should be changed with steps, not by
+// mutating their attributes, but DeepCopy gives a safe starting point for the
+// code that has to do so anyway.
+func (n *Node) DeepCopy() *Node {
+	var marks []*Mark
+	if n.Marks != nil {
+		marks = make([]*Mark, len(n.Marks))
+		for i, mark := range n.Marks {
+			marks[i] = NewMark(mark.Type, copyAttrs(mark.Attrs))
+		}
+	}
+	if n.IsText() {
+		return NewTextNode(n.Type, copyAttrs(n.Attrs), *n.Text, marks)
+	}
+	return NewNode(n.Type, copyAttrs(n.Attrs), n.Content, marks)
+}
+
 // Mark creates a copy of this node, with the given set of marks instead of the
 // node's own marks.
 func (n *Node) Mark(marks []*Mark) *Node {
@@ -257,6 +402,40 @@ func (n *Node) Slice(from int, args ...interface{}) (*Slice, error) {
 	return NewSlice(content, resFrom.Depth-depth, resTo.Depth-depth), nil
 }
 
+// SliceOpen cuts out the part of the document between the given positions,
+// like Slice, but returns a slice with the given open depths. The content is
+// cut at the depth of the from position minus openStart, which must also be
+// the depth of the to position minus openEnd, and must be shared by the two
+// positions. For example, openStart and openEnd can be used to keep the list
+// around some content cut in a list item.
+func (n *Node) SliceOpen(from, to, openStart, openEnd int) (*Slice, error) {
+	resFrom, err := n.Resolve(from)
+	if err != nil {
+		return nil, err
+	}
+	resTo, err := n.Resolve(to)
+	if err != nil {
+		return nil, err
+	}
+	if openStart < 0 || openStart > resFrom.Depth {
+		return nil, fmt.Errorf("Invalid openStart %d for SliceOpen (max %d)", openStart, resFrom.Depth)
+	}
+	if openEnd < 0 || openEnd > resTo.Depth {
+		return nil, fmt.Errorf("Invalid openEnd %d for SliceOpen (max %d)", openEnd, resTo.Depth)
+	}
+	depth := resFrom.Depth - openStart
+	if resTo.Depth-openEnd != depth {
+		return nil, fmt.Errorf("Inconsistent open depths %d and %d for SliceOpen", openStart, openEnd)
+	}
+	if shared := resFrom.SharedDepth(to); depth > shared {
+		return nil, fmt.Errorf("Open depths %d and %d are too small for SliceOpen (min %d and %d)",
+			openStart, openEnd, resFrom.Depth-shared, resTo.Depth-shared)
+	}
+	start := resFrom.Start(depth)
+	content := resFrom.Node(depth).Content.Cut(resFrom.Pos-start, resTo.Pos-start)
+	return NewSlice(content, openStart, openEnd), nil
+}
+
 // Replace the part of the document between the given positions with the given
 // slice. The slice must 'fit', meaning its open sides must be able to connect
 // to the surrounding content, and its content nodes must be valid children for
@@ -284,20 +463,32 @@ func (n *Node) resolveNoCache(pos int) (*ResolvedPos, error) {
 	return resolvePos(n, pos)
 }
 
-// NodeAt finds the node directly after the given position.
+// NodeAt finds the node directly after the given position. It panics if the
+// position is outside of this node, see NodeAtE for a variant that returns an
+// error instead.
 func (n *Node) NodeAt(pos int) *Node {
+	node, err := n.NodeAtE(pos)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// NodeAtE finds the node directly after the given position, or returns an
+// error if the position is outside of this node.
+func (n *Node) NodeAtE(pos int) (*Node, error) {
 	node := n
 	for {
 		index, offset, err := node.Content.findIndex(pos)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		node = node.MaybeChild(index)
 		if node == nil {
-			return nil
+			return nil, nil
 		}
 		if offset == pos || node.IsText() {
-			return node
+			return node, nil
 		}
 		pos -= offset + 1
 	}
@@ -314,6 +505,12 @@ func (n *Node) IsInline() bool {
 	return n.Type.IsInline()
 }
 
+// IsTextblock returns true when this is a textblock node, a block node with
+// inline content.
+func (n *Node) IsTextblock() bool {
+	return n.Type.IsTextblock()
+}
+
 // IsLeaf returns true when this is a leaf node.
 func (n *Node) IsLeaf() bool {
 	return n.Type.IsLeaf()
@@ -342,6 +539,27 @@ func (n *Node) String() string {
 	return wrapMarks(n.Marks, name)
 }
 
+// Digest returns a stable hash of this node, see Fragment.Digest.
+func (n *Node) Digest() string {
+	h := sha256.New()
+	n.writeDigest(h)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (n *Node) writeDigest(h hash.Hash) {
+	h.Write([]byte("(" + strconv.Quote(n.Type.Name)))
+	writeDigestAttrs(h, n.Attrs)
+	for _, m := range n.Marks {
+		h.Write([]byte(strconv.Quote(m.Type.Name)))
+		writeDigestAttrs(h, m.Attrs)
+	}
+	if n.IsText() {
+		h.Write([]byte(strconv.Quote(*n.Text)))
+	}
+	n.Content.writeDigest(h)
+	h.Write([]byte{')'})
+}
+
 // ContentMatchAt gets the content match in this node at the given index.
 func (n *Node) ContentMatchAt(index int) (*ContentMatch, error) {
 	match := n.Type.ContentMatch.MatchFragment(n.Content, 0, index)
@@ -393,14 +611,49 @@ func (n *Node) CanReplace(from, to int, args ...interface{}) bool {
 	return true
 }
 
-// ToJSON converts this node to a JSON-serializeable representation.
-func (n *Node) ToJSON() map[string]interface{} {
+// CanReplaceWith tests whether replacing the range from to to (by index) with
+// a node of the given type would leave the node's content valid.
+//
+// :: (number, number, NodeType, ?[Mark]) → bool
+func (n *Node) CanReplaceWith(from, to int, typ *NodeType, marks []*Mark) bool {
+	if marks != nil && !n.Type.AllowsMarks(marks) {
+		return false
+	}
+	match, err := n.ContentMatchAt(from)
+	if err != nil {
+		return false
+	}
+	start := match.MatchType(typ)
+	if start == nil {
+		return false
+	}
+	end := start.MatchFragment(n.Content, to)
+	return end != nil && end.ValidEnd
+}
+
+// CanAppend tests whether the given node's content could be appended to this
+// node. If that node is empty, this will only return true if there is at
+// least one node type that can appear in both nodes (to avoid merging
+// completely incompatible nodes).
+func (n *Node) CanAppend(other *Node) bool {
+	if other.Content.Size > 0 {
+		count := n.ChildCount()
+		return n.CanReplace(count, count, other.Content)
+	}
+	return n.Type.compatibleContent(other.Type)
+}
+
+// ToJSON converts this node to a JSON-serializeable representation. Like in
+// prosemirror, the attributes with their default values are included, unless
+// omitDefaults is true. In both cases, NodeFromJSON gives back the same node.
+func (n *Node) ToJSON(omitDefaults ...bool) map[string]interface{} {
+	omit := len(omitDefaults) > 0 && omitDefaults[0]
 	obj := map[string]interface{}{"type": n.Type.Name}
-	if len(n.Attrs) > 0 {
-		obj["attrs"] = n.Attrs
+	if attrs := n.jsonAttrs(omit); len(attrs) > 0 {
+		obj["attrs"] = attrs
 	}
 	if n.Content.Size > 0 {
-		obj["content"] = n.Content.ToJSON()
+		obj["content"] = n.Content.ToJSON(omit)
 	}
 	if len(n.Marks) > 0 {
 		var marks []interface{}
@@ -415,8 +668,111 @@ func (n *Node) ToJSON() map[string]interface{} {
 	return obj
 }
 
+func (n *Node) jsonAttrs(omitDefaults bool) map[string]interface{} {
+	attrs := n.Attrs
+	if len(attrs) == 0 && len(n.Type.Attrs) > 0 {
+		attrs = n.Type.DefaultAttrs
+	}
+	if !omitDefaults {
+		return attrs
+	}
+	filtered := map[string]interface{}{}
+	for name, value := range attrs {
+		if attr, ok := n.Type.Attrs[name]; ok && attr.HasDefault && compareDeep(attr.Default, value) {
+			continue
+		}
+		filtered[name] = value
+	}
+	return filtered
+}
+
 // NodeFromJSON deserializes a node from its JSON representation.
 func NodeFromJSON(schema *Schema, raw map[string]interface{}) (*Node, error) {
+	return nodeFromJSON(newJSONImport(schema, nil), raw)
+}
+
+// Substitution describes a change made by NodeFromJSONWithFallback to a
+// document that uses types that are not in the schema: a node of the unknown
+// type From was imported with the type To instead, or a mark of the unknown
+// type From was dropped (and To is empty).
+type Substitution struct {
+	From string
+	To   string
+	Mark bool
+}
+
+// jsonFallback is used to import the JSON of documents with unknown types.
+type jsonFallback struct {
+	fn            func(typeName string) *NodeType
+	substitutions []Substitution
+}
+
+// jsonImport is the state shared by the nodes deserialized together: the
+// fallback for unknown types, if any, and, for a batch, the maps used to look
+// up the types by name.
+type jsonImport struct {
+	schema    *Schema
+	fallback  *jsonFallback
+	nodeTypes map[string]*NodeType
+	markTypes map[string]*MarkType
+}
+
+func newJSONImport(schema *Schema, fallback *jsonFallback) *jsonImport {
+	return &jsonImport{schema: schema, fallback: fallback}
+}
+
+// indexTypes builds the lookup maps, which is worth it only when many nodes
+// are deserialized.
+func (imp *jsonImport) indexTypes() {
+	imp.nodeTypes = make(map[string]*NodeType, len(imp.schema.Nodes))
+	for _, typ := range imp.schema.Nodes {
+		imp.nodeTypes[typ.Name] = typ
+	}
+	imp.markTypes = make(map[string]*MarkType, len(imp.schema.Marks))
+	for _, typ := range imp.schema.Marks {
+		imp.markTypes[typ.Name] = typ
+	}
+}
+
+func (imp *jsonImport) nodeType(name string) (*NodeType, error) {
+	if imp.nodeTypes == nil {
+		return imp.schema.NodeType(name)
+	}
+	if typ, ok := imp.nodeTypes[name]; ok {
+		return typ, nil
+	}
+	return nil, fmt.Errorf("Unknown node type: %s", name)
+}
+
+func (imp *jsonImport) markType(name string) (*MarkType, bool) {
+	if imp.markTypes == nil {
+		return findMarkType(imp.schema.Marks, name)
+	}
+	typ, ok := imp.markTypes[name]
+	return typ, ok
+}
+
+// NodeFromJSONWithFallback deserializes a node from its JSON representation,
+// like NodeFromJSON, but it can import documents that use node or mark types
+// that are not (or no longer) in the schema. The nodes of an unknown type are
+// imported with the type returned by the fallback function, with the default
+// attributes of this type, and the marks of an unknown type are dropped. The
+// list of the substitutions that have been made is returned with the node.
+//
+// When the content of a node doesn't fit the fallback type, and this type is a
+// textblock, the content is replaced by its text. If fallback returns nil, an
+// error is returned as with NodeFromJSON.
+func NodeFromJSONWithFallback(schema *Schema, raw map[string]interface{}, fallback func(typeName string) *NodeType) (*Node, []Substitution, error) {
+	fb := &jsonFallback{fn: fallback}
+	node, err := nodeFromJSON(newJSONImport(schema, fb), raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, fb.substitutions, nil
+}
+
+func nodeFromJSON(imp *jsonImport, raw map[string]interface{}) (*Node, error) {
+	schema, fb := imp.schema, imp.fallback
 	var marks []*Mark
 	if data, ok := raw["marks"]; ok {
 		items, ok := data.([]interface{})
@@ -425,11 +781,17 @@ func NodeFromJSON(schema *Schema, raw map[string]interface{}) (*Node, error) {
 		}
 		for _, item := range items {
 			obj, _ := item.(map[string]interface{})
-			m, err := MarkFromJSON(schema, obj)
-			if err != nil {
-				return nil, err
+			t, _ := obj["type"].(string)
+			markType, ok := imp.markType(t)
+			if !ok {
+				if fb != nil {
+					fb.substitutions = append(fb.substitutions, Substitution{From: t, Mark: true})
+					continue
+				}
+				return nil, fmt.Errorf("There is no mark %s in this schema", obj["type"])
 			}
-			marks = append(marks, m)
+			attrs, _ := obj["attrs"].(map[string]interface{})
+			marks = append(marks, markType.Create(attrs))
 		}
 	}
 	if raw["type"] == "text" {
@@ -437,18 +799,35 @@ func NodeFromJSON(schema *Schema, raw map[string]interface{}) (*Node, error) {
 		if !ok {
 			return nil, errors.New("Invalid text node in JSON")
 		}
+		if text == "" {
+			return nil, errors.New("Empty text nodes are not allowed")
+		}
 		return schema.Text(text, marks), nil
 	}
-	content, err := FragmentFromJSON(schema, raw["content"])
+	content, err := fragmentFromJSON(imp, raw["content"])
 	if err != nil {
 		return nil, err
 	}
 	nodeType, _ := raw["type"].(string)
-	typ, err := schema.NodeType(nodeType)
+	attrs, _ := raw["attrs"].(map[string]interface{})
+	typ, err := imp.nodeType(nodeType)
 	if err != nil {
-		return nil, err
+		if fb == nil || fb.fn == nil {
+			return nil, err
+		}
+		if typ = fb.fn(nodeType); typ == nil {
+			return nil, err
+		}
+		fb.substitutions = append(fb.substitutions, Substitution{From: nodeType, To: typ.Name})
+		attrs = nil
+		if !typ.ValidContent(content) && typ.IsTextblock() {
+			text := content.textBetween(0, content.Size, " ")
+			content = EmptyFragment
+			if text != "" {
+				content = NewFragment([]*Node{schema.Text(text)})
+			}
+		}
 	}
-	attrs, _ := raw["attrs"].(map[string]interface{})
 	return typ.Create(attrs, content, marks)
 }
 
@@ -481,6 +860,19 @@ func asCodeUnits(text string) []uint16 {
 	return utf16.Encode([]rune(text))
 }
 
+// codeUnitsLen returns len(asCodeUnits(text)) without allocating.
+func codeUnitsLen(text string) int {
+	n := 0
+	for _, r := range text {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
 func fromCodeUnits(units []uint16) string {
 	return string(utf16.Decode(units))
 }
@@ -1,6 +1,7 @@
 package model_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	. "github.com/cozy/prosemirror-go/model"
@@ -99,6 +100,45 @@ func TestNodesBetween(t *testing.T) {
 		"paragraph", "foo", "bar", "image", "baz", "hard_break", "quux", "xyz")
 }
 
+func TestNodeWalk(t *testing.T) {
+	d := doc(blockquote(ul(li(p("foo")), li(p("b", em("ar"))))), hr, p("baz"))
+	var events []string
+	var stack []int
+	name := func(node *Node) string {
+		if node.IsText() {
+			return *node.Text
+		}
+		return node.Type.Name
+	}
+	d.Walk(func(node *Node, pos int, parent *Node, index int) bool {
+		assert.Equal(t, node, parent.Content.Content[index])
+		if !node.IsText() {
+			assert.Equal(t, node, d.NodeAt(pos))
+		}
+		events = append(events, "<"+name(node))
+		stack = append(stack, pos)
+		return node.Type.Name != "list_item" || index == 0
+	}, func(node *Node, pos int, parent *Node, index int) {
+		// leave events are balanced with the enter events
+		if assert.NotEmpty(t, stack) {
+			assert.Equal(t, stack[len(stack)-1], pos)
+			stack = stack[:len(stack)-1]
+		}
+		assert.Equal(t, node, parent.Content.Content[index])
+		events = append(events, ">"+name(node))
+	})
+	assert.Empty(t, stack)
+	assert.Equal(t, []string{
+		"<blockquote", "<bullet_list",
+		"<list_item", "<paragraph", "<foo", ">foo", ">paragraph", ">list_item",
+		// doesn't descend when enter returns false
+		"<list_item", ">list_item",
+		">bullet_list", ">blockquote",
+		"<horizontal_rule", ">horizontal_rule",
+		"<paragraph", "<baz", ">baz", ">paragraph",
+	}, events)
+}
+
 func TestNodeTextContent(t *testing.T) {
 	// works on a whole doc
 	assert.Equal(t, doc(p("foo")).TextContent(), "foo")
@@ -162,6 +202,68 @@ func TestNodeToJSON(t *testing.T) {
 
 	// can serialize nested nodes
 	roundTrip(doc(blockquote(ul(li(p("a"), p("b")), li(p(img))), p("c")), p("d")))
+
+	// emits attributes equal to their default
+	heading, err := schema.NodeType("heading")
+	assert.NoError(t, err)
+	h := NewNode(heading, nil, EmptyFragment, NoMarks)
+	assert.Equal(t, map[string]interface{}{"level": 1.0}, h.ToJSON()["attrs"])
+	result, err := NodeFromJSON(schema, doc(h).ToJSON())
+	assert.NoError(t, err)
+	assert.True(t, result.Eq(doc(h1()).Node))
+
+	// can omit attributes equal to their default
+	obj := h1().ToJSON(true)
+	assert.NotContains(t, obj, "attrs")
+	result, err = NodeFromJSON(schema, obj)
+	assert.NoError(t, err)
+	assert.True(t, result.Eq(h1().Node))
+	obj = h2().ToJSON(true)
+	assert.Equal(t, map[string]interface{}{"level": 2}, obj["attrs"])
+	nested := doc(h1("a"), h2("b")).ToJSON(true)
+	result, err = NodeFromJSON(schema, nested)
+	assert.NoError(t, err)
+	assert.True(t, result.Eq(doc(h1("a"), h2("b")).Node))
+}
+
+func TestNodeFromJSONWithFallback(t *testing.T) {
+	var raw map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"type":"doc","content":[
+		{"type":"callout","attrs":{"icon":"!"},"content":[
+			{"type":"text","text":"foo "},
+			{"type":"text","text":"bar","marks":[{"type":"em"},{"type":"highlight","attrs":{"color":"red"}}]}
+		]},
+		{"type":"panel","content":[{"type":"paragraph","content":[{"type":"text","text":"baz"}]}]},
+		{"type":"paragraph","content":[{"type":"text","text":"end"}]}
+	]}`), &raw))
+	paragraph, err := schema.NodeType("paragraph")
+	assert.NoError(t, err)
+	fallback := func(name string) *NodeType {
+		if name == "callout" || name == "panel" {
+			return paragraph
+		}
+		return nil
+	}
+
+	// fails without a fallback
+	_, err = NodeFromJSON(schema, raw)
+	assert.Error(t, err)
+
+	// replaces the unknown nodes and drops the unknown marks
+	result, subs, err := NodeFromJSONWithFallback(schema, raw, fallback)
+	if assert.NoError(t, err) {
+		expected := doc(p("foo ", em("bar")), p("baz"), p("end"))
+		assert.True(t, result.Eq(expected.Node), "%s != %s", result, expected)
+	}
+	assert.Equal(t, []Substitution{
+		{From: "highlight", Mark: true},
+		{From: "callout", To: "paragraph"},
+		{From: "panel", To: "paragraph"},
+	}, subs)
+
+	// fails when the fallback doesn't give a type
+	_, _, err = NodeFromJSONWithFallback(schema, raw, func(string) *NodeType { return nil })
+	assert.Error(t, err)
 }
 
 func TestNodeToString(t *testing.T) {
@@ -225,7 +327,358 @@ func TestNodeSize(t *testing.T) {
 	nodeSize(schema.Text("👥"), 2)
 }
 
+func TestSchemaTextEmpty(t *testing.T) {
+	// rejects empty text nodes
+	assert.Nil(t, schema.Text(""))
+	assert.Nil(t, schema.Text("", []*Mark{em2}))
+
+	// rejects empty text nodes in JSON
+	_, err := NodeFromJSON(schema, map[string]interface{}{"type": "text", "text": ""})
+	assert.Error(t, err)
+	_, err = NodeFromJSON(schema, map[string]interface{}{
+		"type":    "paragraph",
+		"content": []interface{}{map[string]interface{}{"type": "text", "text": ""}},
+	})
+	assert.Error(t, err)
+}
+
 func TestNodeTextBetween(t *testing.T) {
 	txt := schema.Text("hâhîhô", nil)
 	assert.Equal(t, "hî", txt.TextBetween(2, 4))
 }
+
+func TestNodeCanAppend(t *testing.T) {
+	// can append a paragraph to a doc
+	assert.True(t, doc(p("foo")).CanAppend(doc(p("bar")).Node))
+
+	// can append inline content to a paragraph
+	assert.True(t, p("foo").CanAppend(p("bar", img).Node))
+
+	// can't append block content to a paragraph
+	assert.False(t, p("foo").CanAppend(doc(p("bar")).Node))
+
+	// checks compatibility when the other node is empty
+	assert.True(t, p("foo").CanAppend(h1().Node))
+	assert.False(t, p("foo").CanAppend(ul().Node))
+}
+
+func TestNodeCanReplaceWith(t *testing.T) {
+	heading, err := schema.NodeType("heading")
+	assert.NoError(t, err)
+	image, err := schema.NodeType("image")
+	assert.NoError(t, err)
+	text, err := schema.NodeType("text")
+	assert.NoError(t, err)
+
+	// a heading can replace a paragraph
+	assert.True(t, doc(p("foo"), p("bar")).CanReplaceWith(0, 1, heading, nil))
+
+	// an image can't replace a paragraph
+	assert.False(t, doc(p("foo")).CanReplaceWith(0, 1, image, nil))
+
+	// checks the marks
+	assert.True(t, p("foo").CanReplaceWith(1, 1, image, []*Mark{em2}))
+	assert.False(t, pre("foo").CanReplaceWith(0, 0, text, []*Mark{em2}))
+}
+
+func TestNodeTextBetweenBlockSeparator(t *testing.T) {
+	between := func(node builder.NodeWithTag, expected string, args ...string) {
+		assert.Equal(t, expected, node.TextBetween(0, node.Content.Size, args...))
+	}
+
+	// joins paragraphs with the separator
+	between(doc(p("foo"), p("bar"), p("baz")), "foo\nbar\nbaz", "\n")
+
+	// doesn't add a separator for a leading leaf node without text
+	between(doc(hr, p("foo"), p("bar")), "foo\nbar", "\n")
+
+	// uses the leaf text for a leading leaf node
+	between(doc(hr, p("foo")), "--\nfoo", "\n", "--")
+
+	// keeps empty textblocks
+	between(doc(p("foo"), p(), p("bar")), "foo\n\nbar", "\n")
+
+	// works with nested blocks
+	between(doc(blockquote(p("foo"), ul(li(p("bar")))), p("baz")), "foo\nbar\nbaz", "\n")
+
+	// keeps the newlines of a code block verbatim
+	between(doc(p("a"), pre("line1\nline2"), p("b")), "a\nline1\nline2\nb", "\n")
+
+	// doesn't add separators inside a preformatted block
+	nodes := append([]*NodeSpec{}, schema.Spec.Nodes...)
+	nodes = append(nodes, &NodeSpec{Key: "verbatim", Content: "paragraph+", Group: "block", Whitespace: "pre"})
+	preSchema, err := NewSchema(&SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks})
+	assert.NoError(t, err)
+	out := builder.Builders(preSchema, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+	pdoc := out["doc"].(builder.NodeBuilder)
+	pp := out["p"].(builder.NodeBuilder)
+	verbatim := out["verbatim"].(builder.NodeBuilder)
+	between(pdoc(pp("a"), verbatim(pp("x"), pp("y")), pp("b")), "a\nxy\nb", "\n")
+}
+
+func TestTextBetweenFunc(t *testing.T) {
+	nodes := append([]*NodeSpec{}, schema.Spec.Nodes...)
+	nodes = append(nodes, &NodeSpec{
+		Key: "mention", Group: "inline", Inline: true, Atom: true,
+		Attrs: map[string]*AttributeSpec{"name": {}},
+	})
+	mentionSchema, err := NewSchema(&SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks})
+	assert.NoError(t, err)
+	out := builder.Builders(mentionSchema, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+	mdoc := out["doc"].(builder.NodeBuilder)
+	mp := out["p"].(builder.NodeBuilder)
+	mention := out["mention"].(builder.NodeBuilder)
+	mhr := out["horizontal_rule"].(builder.NodeBuilder)
+	d := mdoc(mp("hello ", mention(map[string]interface{}{"name": "alice"}), "!"), mhr, mp("bye"))
+	leafText := func(node *Node) string {
+		if name, ok := node.Attrs["name"].(string); ok {
+			return "@" + name
+		}
+		return "---"
+	}
+
+	// uses the callback for the leaf nodes
+	assert.Equal(t, "hello @alice!\n---\nbye", d.TextBetweenFunc(0, d.Content.Size, "\n", leafText))
+
+	// works on fragments
+	assert.Equal(t, "hello @alice!", d.Content.TextBetweenFunc(0, 10, "", leafText))
+
+	// skips the leaf nodes without a callback
+	assert.Equal(t, "hello !bye", d.TextBetweenFunc(0, d.Content.Size, "", nil))
+}
+
+func TestNodeSpecLeafText(t *testing.T) {
+	nodes := append([]*NodeSpec{}, schema.Spec.Nodes...)
+	nodes = append(nodes, &NodeSpec{
+		Key: "mention", Group: "inline", Inline: true, Atom: true,
+		Attrs: map[string]*AttributeSpec{"name": {}},
+		LeafText: func(node *Node) string {
+			name, _ := node.AttrString("name")
+			return "@" + name
+		},
+	})
+	mentionSchema, err := NewSchema(&SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks})
+	assert.NoError(t, err)
+	out := builder.Builders(mentionSchema, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+	mdoc := out["doc"].(builder.NodeBuilder)
+	mp := out["p"].(builder.NodeBuilder)
+	mention := out["mention"].(builder.NodeBuilder)
+	d := mdoc(mp("hello ", mention(map[string]interface{}{"name": "alice"}), "!"), mp("bye"))
+
+	// is used by TextContent and TextBetween
+	assert.Equal(t, "hello @alice!bye", d.TextContent())
+	assert.Equal(t, "hello @alice!\nbye", d.TextBetween(0, d.Content.Size, "\n"))
+	alice, err := d.Child(0)
+	assert.NoError(t, err)
+	alice, err = alice.Child(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "@alice", alice.TextContent())
+
+	// is overridden by the leafText given by the caller
+	assert.Equal(t, "hello *!bye", d.TextBetween(0, d.Content.Size, "", "*"))
+	assert.Equal(t, "hello alice!bye", d.TextBetweenFunc(0, d.Content.Size, "", func(node *Node) string {
+		name, _ := node.AttrString("name")
+		return name
+	}))
+}
+
+func TestNodeAtE(t *testing.T) {
+	d := doc(p("foo"), p("bar"))
+
+	// finds the node after a position
+	node, err := d.NodeAtE(5)
+	assert.NoError(t, err)
+	assert.True(t, node.Eq(p("bar").Node))
+
+	// returns nil at the end of the document
+	node, err = d.NodeAtE(10)
+	assert.NoError(t, err)
+	assert.Nil(t, node)
+
+	// returns an error for out-of-range positions
+	_, err = d.NodeAtE(11)
+	assert.Error(t, err)
+	_, err = d.NodeAtE(-1)
+	assert.Error(t, err)
+	assert.Panics(t, func() { d.NodeAt(42) })
+}
+
+func TestNodeDeepCopy(t *testing.T) {
+	imgType, err := schema.NodeType("image")
+	assert.NoError(t, err)
+	source, err := imgType.Create(map[string]interface{}{
+		"src":   "img.png",
+		"alt":   "",
+		"title": map[string]interface{}{"parts": []interface{}{"a", "b"}},
+	}, nil, []*Mark{link("http://foo")})
+	assert.NoError(t, err)
+
+	cpy := source.DeepCopy()
+	assert.True(t, cpy.Eq(source))
+
+	// doesn't change the source when the copy is mutated
+	cpy.Attrs["src"] = "other.png"
+	cpy.Attrs["title"].(map[string]interface{})["parts"].([]interface{})[0] = "z"
+	cpy.Marks[0].Attrs["href"] = "http://bar"
+	assert.Equal(t, "img.png", source.Attrs["src"])
+	assert.Equal(t, "a", source.Attrs["title"].(map[string]interface{})["parts"].([]interface{})[0])
+	assert.Equal(t, "http://foo", source.Marks[0].Attrs["href"])
+
+	// doesn't change the siblings sharing the default attrs
+	olType, err := schema.NodeType("ordered_list")
+	assert.NoError(t, err)
+	list, err := olType.Create(nil, li(p("foo")).Node, nil)
+	assert.NoError(t, err)
+	sibling, err := olType.Create(nil, li(p("bar")).Node, nil)
+	assert.NoError(t, err)
+	lcpy := list.DeepCopy()
+	assert.True(t, lcpy.Eq(list))
+	lcpy.Attrs["order"] = 3
+	assert.Equal(t, 1.0, list.Attrs["order"])
+	assert.Equal(t, 1.0, sibling.Attrs["order"])
+
+	// copies text nodes
+	text := schema.Text("foo", []*Mark{em2})
+	tcpy := text.DeepCopy()
+	assert.True(t, tcpy.Eq(text))
+	assert.Equal(t, "foo", *tcpy.Text)
+}
+
+func TestNodeForEachMark(t *testing.T) {
+	type markRange struct {
+		mark     string
+		from, to int
+	}
+	collect := func(n *Node) []markRange {
+		var ranges []markRange
+		n.ForEachMark(func(mark *Mark, from, to int) {
+			ranges = append(ranges, markRange{mark.Type.Name, from, to})
+		})
+		return ranges
+	}
+
+	assert.Equal(t, []markRange{{"em", 2, 4}}, collect(doc(p("a", em("bc"), "d")).Node))
+	assert.Equal(t, []markRange{{"strong", 2, 3}, {"em", 1, 5}},
+		collect(doc(p(em("a", strong("b"), "cd"))).Node))
+	assert.Equal(t, []markRange{{"em", 1, 2}, {"em", 4, 5}},
+		collect(doc(p(em("a")), p(em("b"))).Node))
+	assert.Nil(t, collect(doc(p("foo")).Node))
+}
+
+func TestNodeSameMarkupWithDefaultAttrs(t *testing.T) {
+	heading, err := schema.NodeType("heading")
+	assert.NoError(t, err)
+	explicit, err := heading.Create(map[string]interface{}{"level": 1}, nil, nil)
+	assert.NoError(t, err)
+	byDefault, err := heading.Create(nil, nil, nil)
+	assert.NoError(t, err)
+	fromJSON, err := NodeFromJSON(schema, map[string]interface{}{"type": "heading"})
+	assert.NoError(t, err)
+
+	// compares the values of the attributes
+	assert.True(t, explicit.SameMarkup(byDefault))
+	assert.True(t, byDefault.SameMarkup(explicit))
+	assert.True(t, fromJSON.SameMarkup(explicit))
+	assert.True(t, explicit.HasMarkup(heading))
+
+	// completes the given attributes with the defaults
+	assert.True(t, explicit.HasMarkup(heading, map[string]interface{}{}))
+	assert.True(t, byDefault.HasMarkup(heading, map[string]interface{}{"level": 1.0}))
+	assert.False(t, explicit.HasMarkup(heading, map[string]interface{}{"level": 2}))
+
+	level2, err := heading.Create(map[string]interface{}{"level": 2}, nil, nil)
+	assert.NoError(t, err)
+	assert.False(t, level2.SameMarkup(byDefault))
+	assert.False(t, level2.HasMarkup(heading, map[string]interface{}{}))
+}
+
+func TestNodeWithContent(t *testing.T) {
+	d := doc(h1("title"), p("foo")).Node
+	d.Attrs = map[string]interface{}{"lang": "fr"}
+
+	content := doc(p("bar"), blockquote(p("baz"))).Node.Content
+	replaced, err := d.WithContent(content)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Type, replaced.Type)
+	assert.Equal(t, d.Attrs, replaced.Attrs)
+	assert.True(t, replaced.Content.Eq(content))
+
+	// a doc needs at least one block
+	_, err = d.WithContent(EmptyFragment)
+	assert.EqualError(t, err, "Invalid content for node doc")
+	// a doc can't contain inline content
+	_, err = d.WithContent(p("foo").Node.Content)
+	var invalid *InvalidContentError
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, d.Type, invalid.Type)
+}
+
+func TestNodeAttrGetters(t *testing.T) {
+	raw := `{"type": "doc", "content": [
+		{"type": "heading", "attrs": {"level": 2}, "content": [{"type": "text", "text": "Title"}]},
+		{"type": "ordered_list", "attrs": {"order": 3}, "content": [
+			{"type": "list_item", "content": [{"type": "paragraph"}]}
+		]},
+		{"type": "image", "attrs": {"src": "img.png", "alt": null}}
+	]}`
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(raw), &obj))
+	d, err := NodeFromJSON(schema, obj)
+	assert.NoError(t, err)
+
+	heading := d.FirstChild()
+	assert.Equal(t, 2.0, heading.Attrs["level"])
+	level, ok := heading.AttrInt("level")
+	assert.True(t, ok)
+	assert.Equal(t, 2, level)
+	_, ok = heading.AttrString("level")
+	assert.False(t, ok)
+
+	list := d.MaybeChild(1)
+	order, ok := list.AttrInt("order")
+	assert.True(t, ok)
+	assert.Equal(t, 3, order)
+	_, ok = list.AttrBool("order")
+	assert.False(t, ok)
+	_, ok = list.AttrInt("missing")
+	assert.False(t, ok)
+
+	image := d.LastChild()
+	src, ok := image.AttrString("src")
+	assert.True(t, ok)
+	assert.Equal(t, "img.png", src)
+	_, ok = image.AttrString("alt")
+	assert.False(t, ok)
+
+	// ints set by Go code are accepted too
+	h := h1().Node
+	h.Attrs = map[string]interface{}{"level": 4, "hidden": true}
+	level, ok = h.AttrInt("level")
+	assert.True(t, ok)
+	assert.Equal(t, 4, level)
+	hidden, ok := h.AttrBool("hidden")
+	assert.True(t, ok)
+	assert.True(t, hidden)
+}
+
+func TestNodeFindAll(t *testing.T) {
+	d := doc(h1("Intro"), p("foo"), blockquote(h2("Quoted"), p("bar")), h2("End")).Node
+
+	headings := d.FindAll("heading")
+	assert.Len(t, headings, 3)
+	var texts []string
+	for _, found := range headings {
+		texts = append(texts, found.Node.TextContent())
+		assert.Same(t, found.Node, d.NodeAt(found.Pos))
+		rpos, err := d.Resolve(found.Pos)
+		assert.NoError(t, err)
+		after, err := rpos.NodeAfter()
+		assert.NoError(t, err)
+		assert.Same(t, found.Node, after)
+	}
+	assert.Equal(t, []string{"Intro", "Quoted", "End"}, texts)
+	assert.Equal(t, 0, headings[0].Pos)
+	assert.Equal(t, 13, headings[1].Pos)
+
+	assert.Nil(t, d.FindAll("horizontal_rule"))
+}
@@ -9,6 +9,9 @@ import (
 // replacement.
 type ReplaceError struct {
 	Message string
+	// Err is the more specific error that caused the replacement to fail, if
+	// any (for example, an *InvalidContentError).
+	Err error
 }
 
 // NewReplaceError is the constructor for ReplaceError.
@@ -21,6 +24,11 @@ func (e *ReplaceError) Error() string {
 	return e.Message
 }
 
+// Unwrap returns the error that caused the replacement to fail, if any.
+func (e *ReplaceError) Unwrap() error {
+	return e.Err
+}
+
 // Slice represents a piece cut out of a larger document. It stores not only a
 // fragment, but also the depth up to which nodes on both side are ‘open’ (cut
 // through).
@@ -72,6 +80,46 @@ func (s *Slice) RemoveBetween(from, to int) (*Slice, error) {
 	return NewSlice(removed, s.OpenStart, s.OpenEnd), nil
 }
 
+// Append returns a slice with the content of this slice followed by the
+// content of the other one, open at the start like this slice and at the end
+// like the other one. When this slice is open at its end and the other one at
+// its start, the open nodes that meet at the boundary are joined if they have
+// the same markup, down to the smaller of the two open depths. The other
+// nodes are placed side by side, which closes them.
+func (s *Slice) Append(other *Slice) (*Slice, error) {
+	if s.Content.Size == 0 {
+		return other, nil
+	}
+	if other.Content.Size == 0 {
+		return s, nil
+	}
+	if max := openDepth(s.Content, false); s.OpenEnd > max {
+		return nil, fmt.Errorf("Invalid openEnd %d for Slice.append (max %d)", s.OpenEnd, max)
+	}
+	if max := openDepth(other.Content, true); other.OpenStart > max {
+		return nil, fmt.Errorf("Invalid openStart %d for Slice.append (max %d)", other.OpenStart, max)
+	}
+	depth := s.OpenEnd
+	if other.OpenStart < depth {
+		depth = other.OpenStart
+	}
+	content := joinOpenFragments(s.Content, other.Content, depth)
+	return NewSlice(content, s.OpenStart, other.OpenEnd), nil
+}
+
+// joinOpenFragments appends b to a, joining the last node of a with the first
+// node of b, and so on for their content, for up to depth levels.
+func joinOpenFragments(a, b *Fragment, depth int) *Fragment {
+	if depth > 0 {
+		last, first := a.LastChild(), b.FirstChild()
+		if last.SameMarkup(first) {
+			joined := last.Copy(joinOpenFragments(last.Content, first.Content, depth-1))
+			return a.ReplaceChild(a.ChildCount()-1, joined).Append(b.Cut(first.NodeSize()))
+		}
+	}
+	return a.Append(b)
+}
+
 // Eq tests whether this slice is equal to another slice.
 func (s *Slice) Eq(other *Slice) bool {
 	return s.Content.Eq(other.Content) && s.OpenStart == other.OpenStart && s.OpenEnd == other.OpenEnd
@@ -99,35 +147,78 @@ func (s *Slice) ToJSON() interface{} {
 	return obj
 }
 
-// SliceFromJSON deserializes a slice from its JSON representation.
+// SliceFromJSON deserializes a slice from its JSON representation. It returns
+// an error if the open depths are not consistent with the content.
 func SliceFromJSON(schema *Schema, obj interface{}) (*Slice, error) {
 	if obj == nil {
 		return EmptySlice, nil
 	}
 	data, ok := obj.(map[string]interface{})
 	if !ok {
+		return nil, fmt.Errorf("Invalid input for Slice.fromJSON: %v (%T)", obj, obj)
+	}
+	if len(data) == 0 {
 		return EmptySlice, nil
 	}
-	var openStart, openEnd int
-	switch o := data["openStart"].(type) {
-	case int:
-		openStart = o
-	case float64:
-		openStart = int(o)
+	openStart, err := sliceOpenDepth(data, "openStart")
+	if err != nil {
+		return nil, err
 	}
-	switch o := data["openEnd"].(type) {
-	case int:
-		openEnd = o
-	case float64:
-		openEnd = int(o)
+	openEnd, err := sliceOpenDepth(data, "openEnd")
+	if err != nil {
+		return nil, err
 	}
 	fragment, err := FragmentFromJSON(schema, data["content"])
 	if err != nil {
 		return nil, err
 	}
+	if max := openDepth(fragment, true); openStart > max {
+		return nil, fmt.Errorf("Invalid openStart %d for Slice.fromJSON (max %d)", openStart, max)
+	}
+	if max := openDepth(fragment, false); openEnd > max {
+		return nil, fmt.Errorf("Invalid openEnd %d for Slice.fromJSON (max %d)", openEnd, max)
+	}
 	return NewSlice(fragment, openStart, openEnd), nil
 }
 
+func sliceOpenDepth(data map[string]interface{}, key string) (int, error) {
+	var depth int
+	switch o := data[key].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		depth = o
+	case float64:
+		depth = int(o)
+		if float64(depth) != o {
+			return 0, fmt.Errorf("Invalid %s for Slice.fromJSON: %v", key, o)
+		}
+	default:
+		return 0, fmt.Errorf("Invalid %s for Slice.fromJSON: %v (%T)", key, o, o)
+	}
+	if depth < 0 {
+		return 0, fmt.Errorf("Invalid %s for Slice.fromJSON: %d", key, depth)
+	}
+	return depth, nil
+}
+
+// openDepth returns the maximal depth a slice with the given content can be
+// open at its start (or end).
+func openDepth(fragment *Fragment, start bool) int {
+	depth := 0
+	for {
+		node := fragment.LastChild()
+		if start {
+			node = fragment.FirstChild()
+		}
+		if node == nil || node.IsLeaf() {
+			return depth
+		}
+		depth++
+		fragment = node.Content
+	}
+}
+
 func removeRange(content *Fragment, from, to int) (*Fragment, error) {
 	index, offset, err := content.findIndex(from)
 	if err != nil {
@@ -246,7 +337,7 @@ func joinable(before, after *ResolvedPos, depth int) (*Node, error) {
 
 func addNode(child *Node, target []*Node) []*Node {
 	last := len(target) - 1
-	if last >= 0 && child.IsText() && child.SameMarkup(target[last]) {
+	if last >= 0 && canJoinText(target[last], child) {
 		target[last] = child.WithText(*target[last].Text + *child.Text)
 	} else {
 		target = append(target, child)
@@ -300,7 +391,8 @@ func addRange(start, end *ResolvedPos, depth int, target []*Node) ([]*Node, erro
 // replaceClose in Go is close in JS (close is a reserved keyword in go).
 func replaceClose(node *Node, content *Fragment) (*Node, error) {
 	if !node.Type.ValidContent(content) {
-		return nil, NewReplaceError("Invalid content for node %s", node.Type.Name)
+		err := &InvalidContentError{Type: node.Type}
+		return nil, &ReplaceError{Message: err.Error(), Err: err}
 	}
 	return node.Copy(content), nil
 }
@@ -1,6 +1,7 @@
 package model_test
 
 import (
+	"errors"
 	"testing"
 
 	. "github.com/cozy/prosemirror-go/model"
@@ -142,3 +143,28 @@ func TestNodeReplace(t *testing.T) {
 		doc(blockquote("hi", "<a>"), "<b>"),
 		"Invalid content")
 }
+
+func TestNodeReplaceErrorTypes(t *testing.T) {
+	// reports invalid content both as a replace and a content error
+	d := doc(blockquote("<a>", p("hi")), "<b>")
+	insert := doc(blockquote("hi", "<a>"), "<b>")
+	slice, err := insert.Slice(insert.Tag["a"], insert.Tag["b"])
+	assert.NoError(t, err)
+	_, err = d.Replace(d.Tag["a"], d.Tag["b"], slice)
+	var replaceErr *ReplaceError
+	assert.True(t, errors.As(err, &replaceErr))
+	var contentErr *InvalidContentError
+	if assert.True(t, errors.As(err, &contentErr)) {
+		assert.Equal(t, "blockquote", contentErr.Type.Name)
+	}
+	assert.EqualError(t, err, "Invalid content for node blockquote")
+
+	// doesn't wrap a content error for other replace errors
+	d = doc(p("<a><b>"))
+	insert = doc("<a>", p("<b>"))
+	slice, err = insert.Slice(insert.Tag["a"], insert.Tag["b"])
+	assert.NoError(t, err)
+	_, err = d.Replace(d.Tag["a"], d.Tag["b"], slice)
+	assert.True(t, errors.As(err, &replaceErr))
+	assert.False(t, errors.As(err, &contentErr))
+}
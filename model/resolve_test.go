@@ -1,6 +1,7 @@
 package model_test
 
 import (
+	"errors"
 	"testing"
 
 	. "github.com/cozy/prosemirror-go/model"
@@ -85,3 +86,201 @@ func TestNodeResolve(t *testing.T) {
 		}
 	}
 }
+
+func TestNodeResolveOutOfRange(t *testing.T) {
+	testDoc := doc(p("ab"))
+
+	for _, pos := range []int{-1, 5} {
+		_, err := testDoc.Resolve(pos)
+		var posErr *PositionError
+		if assert.True(t, errors.As(err, &posErr)) {
+			assert.Equal(t, pos, posErr.Pos)
+		}
+	}
+
+	// keeps the same message as before
+	_, err := testDoc.Resolve(5)
+	assert.EqualError(t, err, "Position 5 out of range")
+}
+
+func TestResolvedPosSameParentMinMax(t *testing.T) {
+	d := doc(p("ab"), blockquote(p("cd"), p("ef")))
+	resolve := func(pos int) *ResolvedPos {
+		rp, err := d.Resolve(pos)
+		assert.NoError(t, err)
+		return rp
+	}
+	a, b := resolve(2), resolve(3)
+	c, e := resolve(6), resolve(11)
+
+	// recognizes positions in the same parent
+	assert.True(t, a.SameParent(b))
+	assert.True(t, b.SameParent(a))
+	assert.True(t, resolve(1).SameParent(resolve(3)))
+
+	// recognizes positions in different parents
+	assert.False(t, a.SameParent(c))
+	assert.False(t, c.SameParent(e))
+	assert.False(t, resolve(4).SameParent(resolve(5)))
+
+	// returns the earlier and the later position
+	assert.Equal(t, a, a.Min(b))
+	assert.Equal(t, a, b.Min(a))
+	assert.Equal(t, b, a.Max(b))
+	assert.Equal(t, b, b.Max(a))
+	assert.Equal(t, c, e.Min(c))
+	assert.Equal(t, e, c.Max(e))
+	assert.Equal(t, a, a.Max(resolve(2)))
+}
+
+func TestResolvedPosAtomAfterBefore(t *testing.T) {
+	d := doc(p("a<a>", img, "<b>", img, "<c>b<d>"), hr, "<e>", p("<f>c"))
+	atoms := func(tag string) (*Node, *Node) {
+		rp, err := d.Resolve(d.Tag[tag])
+		assert.NoError(t, err)
+		return rp.AtomBefore(), rp.AtomAfter()
+	}
+	image := d.NodeAt(2)
+	rule := d.NodeAt(d.Tag["e"] - 1)
+
+	// finds an inline atom after the position
+	before, after := atoms("a")
+	assert.Nil(t, before)
+	assert.Equal(t, image, after)
+
+	// finds inline atoms on both sides of the position
+	before, after = atoms("b")
+	assert.Equal(t, image, before)
+	assert.Equal(t, image, after)
+
+	// ignores text nodes
+	before, after = atoms("c")
+	assert.Equal(t, image, before)
+	assert.Nil(t, after)
+	before, after = atoms("d")
+	assert.Nil(t, before)
+	assert.Nil(t, after)
+
+	// finds block atoms
+	before, after = atoms("e")
+	assert.Equal(t, rule, before)
+	assert.Nil(t, after)
+	before, after = atoms("f")
+	assert.Nil(t, before)
+	assert.Nil(t, after)
+}
+
+func TestResolvedPosString(t *testing.T) {
+	testDoc := doc(p("ab"), blockquote(p(em("cd"), "ef")))
+
+	expected := map[int]string{
+		0:  ":0",
+		2:  "paragraph_0:1",
+		4:  ":4",
+		5:  "blockquote_1:0",
+		9:  "blockquote_1/paragraph_0:3",
+		11: "blockquote_1:6",
+		12: ":12",
+	}
+	for pos, str := range expected {
+		rpos, err := testDoc.Resolve(pos)
+		assert.NoError(t, err)
+		assert.Equal(t, str, rpos.String(), "position %d", pos)
+	}
+}
+
+func TestResolvedPosAncestors(t *testing.T) {
+	testDoc := doc(p("a"), blockquote(ul(li(p("bc"))))).Node
+	names := func(nodes []*Node) []string {
+		var result []string
+		for _, node := range nodes {
+			result = append(result, node.Type.Name)
+		}
+		return result
+	}
+
+	// lists the nodes from the document down to the parent
+	rpos, err := testDoc.Resolve(8)
+	assert.NoError(t, err)
+	ancestors := rpos.Ancestors()
+	assert.Equal(t, []string{"doc", "blockquote", "bullet_list", "list_item", "paragraph"}, names(ancestors))
+	for d, node := range ancestors {
+		assert.Same(t, rpos.Node(d), node)
+	}
+
+	// contains only the document at the top level
+	rpos, err = testDoc.Resolve(3)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Node{testDoc}, rpos.Ancestors())
+}
+
+func TestResolvedPosDepths(t *testing.T) {
+	testDoc := doc(blockquote(p("ab"))).Node
+	rpos, err := testDoc.Resolve(3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rpos.Depth)
+
+	// counts negative depths from the innermost node
+	assert.Equal(t, "blockquote", rpos.Node(-1).Type.Name)
+	assert.Equal(t, 1, rpos.Start(-1))
+	assert.Equal(t, 5, rpos.End(-1))
+	before, err := rpos.Before(-1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, before)
+	after, err := rpos.After(-1)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, after)
+
+	// clamps out-of-range depths
+	assert.Equal(t, "doc", rpos.Node(-5).Type.Name)
+	assert.Equal(t, "paragraph", rpos.Node(5).Type.Name)
+	assert.Equal(t, 0, rpos.Start(-5))
+	assert.Equal(t, 6, rpos.End(-5))
+	assert.Equal(t, 2, rpos.Start(5))
+	assert.Equal(t, 4, rpos.End(5))
+	assert.Equal(t, 0, rpos.Index(-5))
+	assert.Equal(t, 1, rpos.IndexAfter(5))
+
+	// accepts the depth below the parent for before and after
+	before, err = rpos.Before(3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, before)
+	after, err = rpos.After(3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, after)
+
+	// returns an error for depths without a position before or after them
+	for _, depth := range []int{0, -2, -3, 4} {
+		_, err = rpos.Before(depth)
+		assert.Error(t, err, "before at depth %d", depth)
+		_, err = rpos.After(depth)
+		assert.Error(t, err, "after at depth %d", depth)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	testDoc := doc(p("ab"), blockquote(p("cd"))).Node
+	resolved, err := testDoc.Resolve(7)
+	assert.NoError(t, err)
+
+	rpos, err := ResolvePath(7, resolved.Path, resolved.ParentOffset)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rpos.Depth)
+	assert.Equal(t, resolved.Parent(), rpos.Parent())
+	assert.Equal(t, resolved.Start(), rpos.Start())
+
+	para := testDoc.FirstChild()
+	bq := testDoc.LastChild()
+	_, err = ResolvePath(1, []interface{}{testDoc, 0}, 1)
+	assert.EqualError(t, err, "Invalid path: length 2 is not a positive multiple of 3")
+	_, err = ResolvePath(1, []interface{}{testDoc, "0", 0}, 1)
+	assert.EqualError(t, err, "Invalid path: expected an index at depth 0, got 0")
+	_, err = ResolvePath(1, []interface{}{0, 0, 0}, 1)
+	assert.EqualError(t, err, "Invalid path: expected a node at depth 0, got 0")
+	_, err = ResolvePath(1, []interface{}{testDoc, 3, 0}, 1)
+	assert.EqualError(t, err, "Invalid path: index 3 out of range at depth 0")
+	_, err = ResolvePath(5, []interface{}{testDoc, 0, 0, bq, 0, 5}, 0)
+	assert.EqualError(t, err, "Invalid path: node at depth 1 is not the child 0 of its parent")
+	_, err = ResolvePath(5, []interface{}{testDoc, 0, 0, para, 0, 1}, 3)
+	assert.EqualError(t, err, "Invalid path: parent offset 3 out of range")
+}
@@ -1,11 +1,23 @@
 package model
 
 import (
-	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 )
 
+// PositionError is the error returned when trying to resolve a position that
+// is outside of the document.
+type PositionError struct {
+	Pos int
+}
+
+// Error returns the error message.
+func (e *PositionError) Error() string {
+	return fmt.Sprintf("Position %d out of range", e.Pos)
+}
+
 // ResolvedPos means resolved position. You can resolve a position to get more
 // information about it. Objects of this class represent such a resolved
 // position, providing various pieces of context information, and some helper
@@ -13,10 +25,15 @@ import (
 //
 // Throughout this interface, methods that take an optional depth parameter
 // will interpret undefined as this.depth and negative numbers as this.depth +
-// value.
+// value. A depth out of the [0, Depth] range is clamped to this range by the
+// methods that return no error, while Before and After return an error for it.
 type ResolvedPos struct {
 	// The position that was resolved.
-	Pos  int
+	Pos int
+	// The path from the root to the parent node, as a flat list of triplets:
+	// for each depth, the ancestor node (*Node), the index of the position in
+	// that node (int), and the absolute start position of the child at that
+	// index (int).
 	Path []interface{}
 	// The number of levels the parent node is from the root. If this
 	// position points directly into the root node, it is 0. If it
@@ -36,6 +53,44 @@ func NewResolvedPos(pos int, path []interface{}, parentOffset int) *ResolvedPos
 	}
 }
 
+// ResolvePath is a checked constructor of ResolvedPos, for the code that
+// builds a path by hand instead of resolving a position in a document. It
+// returns an error if the path is not made of (*Node, int, int) triplets, if
+// an index is out of range, if a node is not the child at the given index of
+// the previous node, or if the parent offset doesn't fit in the parent node.
+func ResolvePath(pos int, path []interface{}, parentOffset int) (*ResolvedPos, error) {
+	if len(path) == 0 || len(path)%3 != 0 {
+		return nil, fmt.Errorf("Invalid path: length %d is not a positive multiple of 3", len(path))
+	}
+	var prev *Node
+	prevIndex := 0
+	for i := 0; i < len(path); i += 3 {
+		depth := i / 3
+		node, ok := path[i].(*Node)
+		if !ok || node == nil {
+			return nil, fmt.Errorf("Invalid path: expected a node at depth %d, got %v", depth, path[i])
+		}
+		index, ok := path[i+1].(int)
+		if !ok {
+			return nil, fmt.Errorf("Invalid path: expected an index at depth %d, got %v", depth, path[i+1])
+		}
+		if _, ok := path[i+2].(int); !ok {
+			return nil, fmt.Errorf("Invalid path: expected an offset at depth %d, got %v", depth, path[i+2])
+		}
+		if index < 0 || index > node.ChildCount() {
+			return nil, fmt.Errorf("Invalid path: index %d out of range at depth %d", index, depth)
+		}
+		if prev != nil && prev.MaybeChild(prevIndex) != node {
+			return nil, fmt.Errorf("Invalid path: node at depth %d is not the child %d of its parent", depth, prevIndex)
+		}
+		prev, prevIndex = node, index
+	}
+	if parentOffset < 0 || parentOffset > prev.Content.Size {
+		return nil, fmt.Errorf("Invalid path: parent offset %d out of range", parentOffset)
+	}
+	return NewResolvedPos(pos, path, parentOffset), nil
+}
+
 func (r *ResolvedPos) resolveDepth(val *int) int {
 	if val == nil {
 		return r.Depth
@@ -46,6 +101,33 @@ func (r *ResolvedPos) resolveDepth(val *int) int {
 	return *val
 }
 
+// clampedDepth is like resolveDepth, but the depth is kept between 0 and
+// r.Depth, so that it can be used to index Path.
+func (r *ResolvedPos) clampedDepth(val *int) int {
+	d := r.resolveDepth(val)
+	if d < 0 {
+		return 0
+	}
+	if d > r.Depth {
+		return r.Depth
+	}
+	return d
+}
+
+// checkedDepth is like resolveDepth, but it returns an error for a depth
+// outside of 1 and r.Depth+1, which don't have a position before or after
+// them.
+func (r *ResolvedPos) checkedDepth(val *int, side string) (int, error) {
+	d := r.resolveDepth(val)
+	if d == 0 {
+		return 0, fmt.Errorf("There is no position %s the top-level node", side)
+	}
+	if d < 0 || d > r.Depth+1 {
+		return 0, fmt.Errorf("Depth %d out of range for a position at depth %d", d, r.Depth)
+	}
+	return d, nil
+}
+
 // Parent returns the parent node that the position points into. Note that even
 // if a position points into a text node, that node is not considered the
 // parent—text nodes are ‘flat’ in this model, and have no content.
@@ -58,6 +140,16 @@ func (r *ResolvedPos) Doc() *Node {
 	return r.Node(0)
 }
 
+// Ancestors returns the nodes that contain this position, from the document
+// (at index 0) down to the parent (at index Depth).
+func (r *ResolvedPos) Ancestors() []*Node {
+	ancestors := make([]*Node, r.Depth+1)
+	for d := range ancestors {
+		ancestors[d] = r.Path[d*3].(*Node)
+	}
+	return ancestors
+}
+
 // Node returns the ancestor node at the given level. p.node(p.depth) is the
 // same as p.parent.
 func (r *ResolvedPos) Node(depth ...int) *Node {
@@ -65,7 +157,7 @@ func (r *ResolvedPos) Node(depth ...int) *Node {
 	if len(depth) > 0 {
 		d = &depth[0]
 	}
-	return r.Path[r.resolveDepth(d)*3].(*Node)
+	return r.Path[r.clampedDepth(d)*3].(*Node)
 }
 
 // Index returns the index into the ancestor at the given level. If this points
@@ -76,7 +168,7 @@ func (r *ResolvedPos) Index(depth ...int) int {
 	if len(depth) > 0 {
 		d = &depth[0]
 	}
-	return r.Path[r.resolveDepth(d)*3+1].(int)
+	return r.Path[r.clampedDepth(d)*3+1].(int)
 }
 
 // IndexAfter returns the index pointing after this position into the ancestor
@@ -86,7 +178,7 @@ func (r *ResolvedPos) IndexAfter(depth ...int) int {
 	if len(depth) > 0 {
 		d = &depth[0]
 	}
-	rd := r.resolveDepth(d)
+	rd := r.clampedDepth(d)
 	offset := 1
 	if rd == r.Depth && r.TextOffset() == 0 {
 		offset = 0
@@ -101,7 +193,7 @@ func (r *ResolvedPos) Start(depth ...int) int {
 	if len(depth) > 0 {
 		d = &depth[0]
 	}
-	rd := r.resolveDepth(d)
+	rd := r.clampedDepth(d)
 	if rd == 0 {
 		return 0
 	}
@@ -114,7 +206,7 @@ func (r *ResolvedPos) End(depth ...int) int {
 	if len(depth) > 0 {
 		d = &depth[0]
 	}
-	rd := r.resolveDepth(d)
+	rd := r.clampedDepth(d)
 	return r.Start(rd) + r.Node(rd).Content.Size
 }
 
@@ -125,9 +217,9 @@ func (r *ResolvedPos) Before(depth ...int) (int, error) {
 	if len(depth) > 0 {
 		d = &depth[0]
 	}
-	rd := r.resolveDepth(d)
-	if rd == 0 {
-		return 0, errors.New("There is no position before the top-level node")
+	rd, err := r.checkedDepth(d, "before")
+	if err != nil {
+		return 0, err
 	}
 	if rd == r.Depth+1 {
 		return r.Pos, nil
@@ -142,9 +234,9 @@ func (r *ResolvedPos) After(depth ...int) (int, error) {
 	if len(depth) > 0 {
 		d = &depth[0]
 	}
-	rd := r.resolveDepth(d)
-	if rd == 0 {
-		return 0, errors.New("There is no position after the top-level node")
+	rd, err := r.checkedDepth(d, "after")
+	if err != nil {
+		return 0, err
 	}
 	if rd == r.Depth+1 {
 		return r.Pos, nil
@@ -202,6 +294,27 @@ func (r *ResolvedPos) NodeBefore() (*Node, error) {
 	return child, nil
 }
 
+// AtomAfter returns the node directly after the position if it is an atom
+// (like an image), which the cursor should jump over or select as a whole,
+// and nil otherwise. Text nodes are not considered as atoms here.
+func (r *ResolvedPos) AtomAfter() *Node {
+	node, err := r.NodeAfter()
+	if err != nil || node == nil || node.IsText() || !node.IsAtom() {
+		return nil
+	}
+	return node
+}
+
+// AtomBefore returns the node directly before the position if it is an atom,
+// and nil otherwise. See AtomAfter.
+func (r *ResolvedPos) AtomBefore() *Node {
+	node, err := r.NodeBefore()
+	if err != nil || node == nil || node.IsText() || !node.IsAtom() {
+		return nil
+	}
+	return node
+}
+
 // Marks gets the marks at this position, factoring in the surrounding marks'
 // inclusive property. If the position is at the start of a non-empty node, the
 // marks of the node after it (if any) are returned.
@@ -254,9 +367,48 @@ func (r *ResolvedPos) SharedDepth(pos int) int {
 	return 0
 }
 
+// SameParent queries whether the given position shares the same parent node.
+func (r *ResolvedPos) SameParent(other *ResolvedPos) bool {
+	return r.Pos-r.ParentOffset == other.Pos-other.ParentOffset
+}
+
+// Max returns the greater of this and the given position.
+func (r *ResolvedPos) Max(other *ResolvedPos) *ResolvedPos {
+	if other.Pos > r.Pos {
+		return other
+	}
+	return r
+}
+
+// Min returns the smaller of this and the given position.
+func (r *ResolvedPos) Min(other *ResolvedPos) *ResolvedPos {
+	if other.Pos < r.Pos {
+		return other
+	}
+	return r
+}
+
+// String returns a debugging representation of the resolved position: the
+// type and index in its parent of each ancestor node, followed by the offset
+// in the parent node, like "blockquote_1/paragraph_0:3".
+func (r *ResolvedPos) String() string {
+	var sb strings.Builder
+	for i := 1; i <= r.Depth; i++ {
+		if i > 1 {
+			sb.WriteByte('/')
+		}
+		sb.WriteString(r.Node(i).Type.Name)
+		sb.WriteByte('_')
+		sb.WriteString(strconv.Itoa(r.Index(i - 1)))
+	}
+	sb.WriteByte(':')
+	sb.WriteString(strconv.Itoa(r.ParentOffset))
+	return sb.String()
+}
+
 func resolvePos(doc *Node, pos int) (*ResolvedPos, error) {
 	if !(pos >= 0 && pos <= doc.Content.Size) {
-		return nil, fmt.Errorf("Position %d out of range", pos)
+		return nil, &PositionError{Pos: pos}
 	}
 	path := []interface{}{}
 	start := 0
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -15,7 +16,7 @@ import (
 func defaultAttrs(attrs map[string]*Attribute) map[string]interface{} {
 	defaults := map[string]interface{}{}
 	for name, attr := range attrs {
-		if !attr.HasDefault {
+		if !attr.HasDefault || attr.isComputed() {
 			return nil
 		}
 		defaults[name] = attr.Default
@@ -36,6 +37,9 @@ func computeAttrs(attrs map[string]*Attribute, value ...map[string]interface{})
 				panic(fmt.Errorf("No value supplied for attribute %s", name))
 			}
 			given = attr.Default
+			if attr.isComputed() {
+				given = attr.DefaultFn()
+			}
 		}
 		built[name] = given
 	}
@@ -102,6 +106,12 @@ func (nt *NodeType) IsInline() bool {
 	return !nt.IsBlock()
 }
 
+// IsTextblock returns true if this is a textblock type, a block that contains
+// inline content.
+func (nt *NodeType) IsTextblock() bool {
+	return nt.IsBlock() && nt.InlineContent
+}
+
 // IsLeaf returns true for node types that allow no content.
 func (nt *NodeType) IsLeaf() bool {
 	return nt.ContentMatch == EmptyContentMatch
@@ -113,6 +123,15 @@ func (nt *NodeType) IsAtom() bool {
 	return nt.IsLeaf() || nt.Spec.Atom
 }
 
+// Whitespace returns how the whitespace in nodes of this type should be
+// handled: "pre" to preserve it, or "normal" (the default).
+func (nt *NodeType) Whitespace() string {
+	if nt.Spec.Whitespace != "" {
+		return nt.Spec.Whitespace
+	}
+	return "normal"
+}
+
 // HasRequiredAttrs tells you whether this node type has any required
 // attributes.
 func (nt *NodeType) HasRequiredAttrs() bool {
@@ -129,7 +148,7 @@ func (nt *NodeType) compatibleContent(other *NodeType) bool {
 }
 
 func (nt *NodeType) computeAttrs(attrs map[string]interface{}) map[string]interface{} {
-	if len(attrs) == 0 && len(nt.DefaultAttrs) > 0 {
+	if len(attrs) == 0 && nt.DefaultAttrs != nil {
 		return nt.DefaultAttrs
 	}
 	return computeAttrs(nt.Attrs, attrs)
@@ -150,6 +169,17 @@ func (nt *NodeType) Create(attrs map[string]interface{}, content interface{}, ma
 	return NewNode(nt, nt.computeAttrs(attrs), fragment, MarkSetFrom(marks)), nil
 }
 
+// InvalidContentError is the error returned when some content doesn't match
+// the content expression of a node type.
+type InvalidContentError struct {
+	Type *NodeType
+}
+
+// Error returns the error message.
+func (e *InvalidContentError) Error() string {
+	return fmt.Sprintf("Invalid content for node %s", e.Type.Name)
+}
+
 // CreateChecked is like create, but check the given content against the node
 // type's content restrictions, and throw an error if it doesn't match.
 //
@@ -181,7 +211,7 @@ func (nt *NodeType) CreateChecked(args ...interface{}) (*Node, error) {
 		return nil, err
 	}
 	if !nt.ValidContent(fragment) {
-		return nil, fmt.Errorf("Invalid content for node %s", nt.Name)
+		return nil, &InvalidContentError{Type: nt}
 	}
 	return NewNode(nt, nt.computeAttrs(attrs), fragment, MarkSetFrom(marks)), nil
 }
@@ -192,7 +222,11 @@ func (nt *NodeType) CreateChecked(args ...interface{}) (*Node, error) {
 // nodes can always be created, this will always succeed if you pass null or
 // Fragment.empty as content.
 //
-// :: (?Object, ?union<Fragment, Node, [Node]>, ?[Mark]) → ?Node
+// A preferred node type can be given as fourth argument: when the content
+// expression allows several types for the filler nodes, this one is used if
+// possible.
+//
+// :: (?Object, ?union<Fragment, Node, [Node]>, ?[Mark], ?NodeType) → ?Node
 func (nt *NodeType) CreateAndFill(args ...interface{}) (*Node, error) {
 	var attrs map[string]interface{}
 	if len(args) > 0 && args[0] != nil {
@@ -214,6 +248,14 @@ func (nt *NodeType) CreateAndFill(args ...interface{}) (*Node, error) {
 		}
 		marks = arg
 	}
+	var prefer *NodeType
+	if len(args) > 3 && args[3] != nil {
+		arg, ok := args[3].(*NodeType)
+		if !ok {
+			return nil, fmt.Errorf("Invalid type for preferred node type: %v (%T)", args[3], args[3])
+		}
+		prefer = arg
+	}
 
 	attrs = nt.computeAttrs(attrs)
 	fragment, err := FragmentFrom(content)
@@ -221,32 +263,40 @@ func (nt *NodeType) CreateAndFill(args ...interface{}) (*Node, error) {
 		return nil, err
 	}
 	if fragment.Size > 0 {
-		before := nt.ContentMatch.FillBefore(fragment)
+		before := nt.ContentMatch.FillBeforePreferring(fragment, false, prefer)
 		if before == nil {
 			return nil, nil
 		}
 		fragment = before.Append(fragment)
 	}
-	after := nt.ContentMatch.MatchFragment(fragment).FillBefore(EmptyFragment, true)
+	after := nt.ContentMatch.MatchFragment(fragment).FillBeforePreferring(EmptyFragment, true, prefer)
 	if after == nil {
 		return nil, nil
 	}
 	return NewNode(nt, attrs, fragment.Append(after), MarkSetFrom(marks)), nil
 }
 
+// DefaultContent returns the content that CreateAndFill gives to a node of
+// this type created without content: the nodes required by its content
+// expression, filled in their turn.
+func (nt *NodeType) DefaultContent() (*Fragment, error) {
+	fill := nt.ContentMatch.FillBefore(EmptyFragment, true)
+	if fill == nil {
+		return nil, fmt.Errorf("Can't fill the content of %s", nt.Name)
+	}
+	return fill, nil
+}
+
 // ValidContent returns true if the given fragment is valid content for this
 // node type with the given attributes.
 func (nt *NodeType) ValidContent(content *Fragment) bool {
-	result := nt.ContentMatch.MatchFragment(content)
-	if result == nil || !result.ValidEnd {
-		return false
-	}
+	match := nt.ContentMatch
 	for _, child := range content.Content {
-		if !nt.AllowsMarks(child.Marks) {
+		if match = match.MatchNode(child, nt); match == nil {
 			return false
 		}
 	}
-	return true
+	return match.ValidEnd
 }
 
 // AllowsMarkType checks whether the given mark type is allowed in this node.
@@ -285,17 +335,24 @@ func findNoteType(types []*NodeType, key string) (*NodeType, bool) {
 }
 
 func compileNodeType(nodes []*NodeSpec, schema *Schema) ([]*NodeType, error) {
+	topType := schema.Spec.TopNode
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("The schema has no node types: it needs at least a top node type (%s) and a 'text' type", topType)
+	}
 	var result []*NodeType
 	for _, n := range nodes {
 		nt := NewNodeType(n.Key, schema, n)
 		result = append(result, nt)
 	}
-	topType := schema.Spec.TopNode
-	if _, ok := findNoteType(result, topType); !ok {
+	_, hasTop := findNoteType(result, topType)
+	txt, hasText := findNoteType(result, "text")
+	if !hasTop && !hasText {
+		return nil, fmt.Errorf("The schema is missing its top node type (%s) and its 'text' type", topType)
+	}
+	if !hasTop {
 		return nil, fmt.Errorf("The schema is missing its top node type (%s)", topType)
 	}
-	txt, ok := findNoteType(result, "text")
-	if !ok {
+	if !hasText {
 		return nil, errors.New("Every schema needs a 'text' type")
 	}
 	if len(txt.Attrs) > 0 {
@@ -308,18 +365,25 @@ func compileNodeType(nodes []*NodeSpec, schema *Schema) ([]*NodeType, error) {
 type Attribute struct {
 	HasDefault bool
 	Default    interface{}
+	DefaultFn  func() interface{}
 }
 
 func (a *Attribute) isRequired() bool {
 	return !a.HasDefault
 }
 
+// isComputed returns true if the default value of this attribute is computed
+// by DefaultFn for each node or mark.
+func (a *Attribute) isComputed() bool {
+	return a.Default == nil && a.DefaultFn != nil
+}
+
 // NewAttribute is the constructor for Attribute.
 func NewAttribute(options *AttributeSpec) *Attribute {
 	if options == nil {
 		return &Attribute{HasDefault: false, Default: nil}
 	}
-	return &Attribute{HasDefault: true, Default: options.Default}
+	return &Attribute{HasDefault: true, Default: options.Default, DefaultFn: options.DefaultFn}
 }
 
 // MarkType is the type object for marks. Like nodes, marks (which are
@@ -336,6 +400,10 @@ type MarkType struct {
 	Excluded []*MarkType
 	Attrs    map[string]*Attribute
 	Instance *Mark
+
+	// The position of the mark type in the schema spec, used to order the
+	// marks with the same rank.
+	index int
 }
 
 // NewMarkType is the constructor for MarkType.
@@ -367,12 +435,32 @@ func (mt *MarkType) Create(attrs map[string]interface{}) *Mark {
 func compileMarkType(marks []*MarkSpec, schema *Schema) []*MarkType {
 	var result []*MarkType
 	for i, m := range marks {
-		mt := NewMarkType(m.Key, i, schema, m)
+		rank := i
+		if m.Rank != nil {
+			rank = *m.Rank
+		}
+		mt := NewMarkType(m.Key, rank, schema, m)
+		mt.index = i
 		result = append(result, mt)
 	}
 	return result
 }
 
+// sortsBefore tells if the marks of this type come before the marks of the
+// other type in a mark set: by rank, and then by position in the schema spec.
+func (mt *MarkType) sortsBefore(other *MarkType) bool {
+	if mt.Rank != other.Rank {
+		return mt.Rank < other.Rank
+	}
+	return mt.index < other.index
+}
+
+// IsSpanning returns true if the marks of this type can span multiple
+// adjacent nodes when serialized.
+func (mt *MarkType) IsSpanning() bool {
+	return mt.Spec.Spanning == nil || *mt.Spec.Spanning
+}
+
 // IsInSet tests whether there is a mark of this type in the given set.
 func (mt *MarkType) IsInSet(set []*Mark) *Mark {
 	for _, mark := range set {
@@ -383,7 +471,30 @@ func (mt *MarkType) IsInSet(set []*Mark) *Mark {
 	return nil
 }
 
-// Excludes queries whether a given mark type is excluded by this one.
+// RemoveFromSet removes all the marks of this type from the given set, whatever
+// their attributes are.
+func (mt *MarkType) RemoveFromSet(set []*Mark) []*Mark {
+	var result []*Mark
+	for i, mark := range set {
+		if mark.Type == mt {
+			if result == nil {
+				result = make([]*Mark, i, len(set)-1)
+				copy(result, set[:i])
+			}
+		} else if result != nil {
+			result = append(result, mark)
+		}
+	}
+	if result == nil {
+		return set
+	}
+	return result
+}
+
+// Excludes queries whether a given mark type is excluded by this one. The
+// groups named in the excludes spec are resolved to their member mark types
+// when the schema is built. As a schema can't be modified after its creation,
+// it covers all the members of those groups.
 func (mt *MarkType) Excludes(other *MarkType) bool {
 	if len(mt.Excluded) == 0 {
 		return false
@@ -450,6 +561,15 @@ func SchemaSpecFromJSON(raw map[string]interface{}) SchemaSpec {
 				if inline, ok := data["inline"].(bool); ok {
 					n.Inline = inline
 				}
+				if atom, ok := data["atom"].(bool); ok {
+					n.Atom = atom
+				}
+				if whitespace, ok := data["whitespace"].(string); ok {
+					n.Whitespace = whitespace
+				}
+				if replacement, ok := data["linebreakReplacement"].(bool); ok {
+					n.LinebreakReplacement = replacement
+				}
 				if attrs, ok := data["attrs"].(map[string]interface{}); ok {
 					n.Attrs = make(map[string]*AttributeSpec)
 					for k, v := range attrs {
@@ -489,6 +609,13 @@ func SchemaSpecFromJSON(raw map[string]interface{}) SchemaSpec {
 				if group, ok := data["group"].(string); ok {
 					m.Group = group
 				}
+				if spanning, ok := data["spanning"].(bool); ok {
+					m.Spanning = &spanning
+				}
+				if rank, ok := data["rank"].(float64); ok {
+					r := int(rank)
+					m.Rank = &r
+				}
 				spec.Marks = append(spec.Marks, m)
 			}
 		}
@@ -498,7 +625,115 @@ func SchemaSpecFromJSON(raw map[string]interface{}) SchemaSpec {
 	return spec
 }
 
-// MarshalJSON creates a JSON representation of the SchemaSpec.
+// SchemaSpecFromJSONStrict is like SchemaSpecFromJSON, but instead of
+// skipping the malformed entries, it returns an error that identifies the
+// first one (a node or mark that isn't a [name, spec] pair, a name that is
+// not a string, a property or attributes with a wrong type, etc.).
+func SchemaSpecFromJSONStrict(raw map[string]interface{}) (SchemaSpec, error) {
+	if err := checkSpecEntries(raw, "nodes", "node", nodeSpecJSONTypes); err != nil {
+		return SchemaSpec{}, err
+	}
+	if err := checkSpecEntries(raw, "marks", "mark", markSpecJSONTypes); err != nil {
+		return SchemaSpec{}, err
+	}
+	if top, ok := raw["topNode"]; ok {
+		if _, ok := top.(string); !ok {
+			return SchemaSpec{}, fmt.Errorf("Invalid schema spec: topNode should be a string, got %v", top)
+		}
+	}
+	return SchemaSpecFromJSON(raw), nil
+}
+
+// The expected JSON types ("string", "bool" or "number") of the properties of
+// the node and mark specs.
+var (
+	nodeSpecJSONTypes = [][2]string{
+		{"content", "string"},
+		{"marks", "string"},
+		{"group", "string"},
+		{"inline", "bool"},
+		{"atom", "bool"},
+		{"whitespace", "string"},
+		{"linebreakReplacement", "bool"},
+	}
+	markSpecJSONTypes = [][2]string{
+		{"inclusive", "bool"},
+		{"excludes", "string"},
+		{"group", "string"},
+		{"spanning", "bool"},
+		{"rank", "number"},
+	}
+)
+
+func checkSpecEntries(raw map[string]interface{}, field, kind string, types [][2]string) error {
+	value, ok := raw[field]
+	if !ok || value == nil {
+		return nil
+	}
+	entries, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid schema spec: %s should be an array, got %v", field, value)
+	}
+	for i, entry := range entries {
+		tuple, ok := entry.([]interface{})
+		if !ok || len(tuple) != 2 {
+			return fmt.Errorf("Invalid %s spec #%d: expected a [name, spec] pair, got %v", kind, i, entry)
+		}
+		name, ok := tuple[0].(string)
+		if !ok {
+			return fmt.Errorf("Invalid %s spec #%d: the name should be a string, got %v", kind, i, tuple[0])
+		}
+		data, ok := tuple[1].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Invalid %s spec %q: the spec should be an object, got %v", kind, name, tuple[1])
+		}
+		for _, typ := range types {
+			prop, expected := typ[0], typ[1]
+			v, ok := data[prop]
+			if !ok {
+				continue
+			}
+			valid := false
+			switch expected {
+			case "string":
+				_, valid = v.(string)
+			case "bool":
+				_, valid = v.(bool)
+			case "number":
+				_, valid = v.(float64)
+			}
+			if !valid {
+				return fmt.Errorf("Invalid %s spec %q: %s should be a %s, got %v", kind, name, prop, expected, v)
+			}
+		}
+		if attrs, ok := data["attrs"]; ok {
+			attrsMap, ok := attrs.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("Invalid %s spec %q: attrs should be an object, got %v", kind, name, attrs)
+			}
+			for _, attr := range sortedKeys(attrsMap) {
+				v := attrsMap[attr]
+				if _, ok := v.(map[string]interface{}); !ok {
+					return fmt.Errorf("Invalid %s spec %q: attribute %q should be an object, got %v", kind, name, attr, v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalJSON creates a JSON representation of the SchemaSpec. The nodes and
+// marks are kept in their order, and encoding/json sorts the keys of the
+// attrs maps, so the output is stable for a given spec.
 func (s SchemaSpec) MarshalJSON() ([]byte, error) {
 	if len(s.Nodes) == 0 && len(s.Marks) == 0 && len(s.TopNode) == 0 {
 		return []byte(`{}`), nil
@@ -508,11 +743,17 @@ func (s SchemaSpec) MarshalJSON() ([]byte, error) {
 	if len(s.Nodes) > 0 {
 		buf = append(buf, []byte(`,"nodes":[`)...)
 		for _, node := range s.Nodes {
+			key, err := json.Marshal(node.Key)
+			if err != nil {
+				return nil, err
+			}
 			val, err := json.Marshal(node)
 			if err != nil {
 				return nil, err
 			}
-			buf = append(buf, []byte(`["`+node.Key+`",`)...)
+			buf = append(buf, '[')
+			buf = append(buf, key...)
+			buf = append(buf, ',')
 			buf = append(buf, val...)
 			buf = append(buf, ']', ',')
 		}
@@ -522,11 +763,17 @@ func (s SchemaSpec) MarshalJSON() ([]byte, error) {
 	if len(s.Marks) > 0 {
 		buf = append(buf, []byte(`,"marks":[`)...)
 		for _, mark := range s.Marks {
+			key, err := json.Marshal(mark.Key)
+			if err != nil {
+				return nil, err
+			}
 			val, err := json.Marshal(mark)
 			if err != nil {
 				return nil, err
 			}
-			buf = append(buf, []byte(`["`+mark.Key+`",`)...)
+			buf = append(buf, '[')
+			buf = append(buf, key...)
+			buf = append(buf, ',')
 			buf = append(buf, val...)
 			buf = append(buf, ']', ',')
 		}
@@ -534,7 +781,12 @@ func (s SchemaSpec) MarshalJSON() ([]byte, error) {
 	}
 
 	if len(s.TopNode) > 0 {
-		buf = append(buf, []byte(`,"topNode":"`+s.TopNode+`"`)...)
+		top, err := json.Marshal(s.TopNode)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, []byte(`,"topNode":`)...)
+		buf = append(buf, top...)
 	}
 	buf[0] = '{'
 	buf = append(buf, '}')
@@ -558,11 +810,9 @@ func (s *SchemaSpec) UnmarshalJSON(buf []byte) error {
 		if err := json.Unmarshal(n[1], &node); err != nil {
 			return err
 		}
-		if len(n[0]) < 2 {
+		if err := json.Unmarshal(n[0], &node.Key); err != nil {
 			return errors.New("Invalid node key")
 		}
-		key := []byte(n[0])
-		node.Key = string(key[1 : len(key)-1]) // Remove the "" around the key
 		s.Nodes = append(s.Nodes, &node)
 	}
 
@@ -572,11 +822,9 @@ func (s *SchemaSpec) UnmarshalJSON(buf []byte) error {
 		if err := json.Unmarshal(m[1], &mark); err != nil {
 			return err
 		}
-		if len(m[0]) < 2 {
+		if err := json.Unmarshal(m[0], &mark.Key); err != nil {
 			return errors.New("Invalid mark key")
 		}
-		key := []byte(m[0])
-		mark.Key = string(key[1 : len(key)-1]) // Remove the "" around the key
 		s.Marks = append(s.Marks, &mark)
 	}
 
@@ -614,12 +862,28 @@ type NodeSpec struct {
 	// content and should be treated as a single unit in the view.
 	Atom bool `json:"atom,omitempty"`
 
+	// Controls the way whitespace in this node is parsed. The default is
+	// "normal", which causes the parsers to collapse whitespace, and "pre"
+	// causes them to preserve spaces inside the node.
+	Whitespace string `json:"whitespace,omitempty"`
+
+	// Allows a single node to be set as a linebreak equivalent (e.g. in a
+	// schema where line breaks are modeled as a dedicated inline leaf node).
+	// Such nodes contribute a newline when the text of a document is
+	// extracted.
+	LinebreakReplacement bool `json:"linebreakReplacement,omitempty"`
+
 	// The attributes that nodes of this type get.
 	Attrs map[string]*AttributeSpec `json:"attrs,omitempty"`
 
 	// Defines the default way a node of this type should be serialized to a
 	// string representation for debugging (e.g. in error messages).
 	ToDebugString func(*Node) string `json:"-"`
+
+	// Defines the default way a leaf node of this type should be serialized
+	// to a string (as used by Node.TextBetween and Node.TextContent), when no
+	// leafText is given by the caller.
+	LeafText func(*Node) string `json:"-"`
 }
 
 // MarkSpec is an object describing a mark type.
@@ -653,6 +917,18 @@ type MarkSpec struct {
 
 	// The group or space-separated groups to which this mark belongs.
 	Group string `json:"group,omitempty"`
+
+	// Determines whether marks of this type can span multiple adjacent
+	// nodes when serialized. When false, the markdown serializer closes and
+	// opens them again around each node. Defaults to true.
+	Spanning *bool `json:"spanning,omitempty"`
+
+	// The rank of this mark type, which determines the order of the marks
+	// in a mark set (marks with a lower rank come first, i.e. they are the
+	// outer ones when serialized). Defaults to the position of the mark in
+	// the schema spec. Marks with the same rank are ordered by their position
+	// in the schema spec.
+	Rank *int `json:"rank,omitempty"`
 }
 
 // AttributeSpec is used to define attributes on nodes or marks.
@@ -661,6 +937,12 @@ type AttributeSpec struct {
 	// provided. Attributes that have no default must be provided whenever a
 	// node or mark of a type that has them is created.
 	Default interface{} `json:"default,omitempty"`
+
+	// A function that computes the default value of this attribute (like a
+	// generated id) each time a node or mark is created without an explicit
+	// value. It is only used when there is no static Default. It can't be
+	// serialized in JSON.
+	DefaultFn func() interface{} `json:"-"`
 }
 
 // Schema is a a document schema: it holds node and mark type objects for the
@@ -675,6 +957,9 @@ type Schema struct {
 
 	// A map from mark names to mark type objects.
 	Marks []*MarkType
+
+	// The linebreak replacement node defined in this schema, if any.
+	LinebreakReplacement *NodeType
 }
 
 // NewSchema constructs a schema from a schema specification.
@@ -703,12 +988,21 @@ func NewSchema(spec *SchemaSpec) (*Schema, error) {
 		if !ok {
 			cm, err = ParseContentMatch(contentExpr, schema.Nodes)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("Invalid content expression for node %s: %w", typ.Name, err)
 			}
 			contentExprCache[contentExpr] = cm
 		}
 		typ.ContentMatch = cm
 		typ.InlineContent = typ.ContentMatch.inlineContent()
+		if typ.Spec.LinebreakReplacement {
+			if schema.LinebreakReplacement != nil {
+				return nil, errors.New("Multiple linebreak nodes defined")
+			}
+			if !typ.IsInline() || !typ.IsLeaf() {
+				return nil, errors.New("Linebreak replacement nodes must be inline leaf nodes")
+			}
+			schema.LinebreakReplacement = typ
+		}
 		if markExpr == nil {
 			if !typ.InlineContent {
 				var set []*MarkType
@@ -720,9 +1014,9 @@ func NewSchema(spec *SchemaSpec) (*Schema, error) {
 		} else if *markExpr == "_" {
 			typ.MarkSet = nil
 		} else {
-			set, err := gatherMarks(&schema, strings.Split(*markExpr, " "))
+			set, err := gatherMarks(&schema, strings.Fields(*markExpr))
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("Invalid marks for node %s: %w", typ.Name, err)
 			}
 			typ.MarkSet = &set
 		}
@@ -737,7 +1031,7 @@ func NewSchema(spec *SchemaSpec) (*Schema, error) {
 		} else {
 			gathered, err := gatherMarks(&schema, strings.Fields(*excl))
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("Invalid excludes for mark %s: %w", typ.Name, err)
 			}
 			typ.Excluded = gathered
 		}
@@ -790,12 +1084,16 @@ func (s *Schema) Node(typ interface{}, args ...interface{}) (*Node, error) {
 	return t.CreateChecked(attrs, content, marks)
 }
 
-// Text creates a text node in the schema. Empty text nodes are not allowed.
+// Text creates a text node in the schema. Empty text nodes are not allowed:
+// nil is returned when text is empty.
 func (s *Schema) Text(text string, marks ...[]*Mark) *Node {
 	typ, ok := findNoteType(s.Nodes, "text")
 	if !ok {
 		panic(errors.New("No text node type"))
 	}
+	if text == "" {
+		return nil
+	}
 	set := NoMarks
 	if len(marks) > 0 {
 		set = MarkSetFrom(marks[0])
@@ -853,6 +1151,66 @@ func (s *Schema) MarkType(name string) (*MarkType, error) {
 	return nil, fmt.Errorf("Unknown mark type: %s", name)
 }
 
+// NodeTypesInGroup returns the node types of this schema that are in the given
+// group, in the order of the schema.
+func (s *Schema) NodeTypesInGroup(group string) []*NodeType {
+	var found []*NodeType
+	for _, typ := range s.Nodes {
+		if hasGroup(typ.Spec.Group, group) {
+			found = append(found, typ)
+		}
+	}
+	return found
+}
+
+// MarkTypesInGroup returns the mark types of this schema that are in the given
+// group, in the order of the schema.
+func (s *Schema) MarkTypesInGroup(group string) []*MarkType {
+	var found []*MarkType
+	for _, typ := range s.Marks {
+		if hasGroup(typ.Spec.Group, group) {
+			found = append(found, typ)
+		}
+	}
+	return found
+}
+
+// EmptyDoc returns the smallest valid document of this schema: a node of the
+// top node type, with its default attributes, and filled with the required
+// content, if any.
+func (s *Schema) EmptyDoc() (*Node, error) {
+	top, err := s.NodeType(s.Spec.TopNode)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := top.CreateAndFill()
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("Can't fill the content of %s", top.Name)
+	}
+	return doc, nil
+}
+
+// NodesFromJSON deserializes a batch of nodes from their JSON
+// representation, like NodeFromJSON, but the lookups of the node and mark
+// types by their names are shared by all the nodes, which is faster for
+// importing many documents.
+func (s *Schema) NodesFromJSON(raws []map[string]interface{}) ([]*Node, error) {
+	imp := newJSONImport(s, nil)
+	imp.indexTypes()
+	nodes := make([]*Node, len(raws))
+	for i, raw := range raws {
+		node, err := nodeFromJSON(imp, raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid node #%d: %w", i, err)
+		}
+		nodes[i] = node
+	}
+	return nodes, nil
+}
+
 func gatherMarks(schema *Schema, marks []string) ([]*MarkType, error) {
 	var found []*MarkType
 	for _, name := range marks {
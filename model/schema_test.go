@@ -2,10 +2,13 @@ package model_test
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 
 	. "github.com/cozy/prosemirror-go/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSchemaSpecFromJSON(t *testing.T) {
@@ -17,3 +20,514 @@ func TestSchemaSpecFromJSON(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, spec, actual)
 }
+
+func TestSchemaSpecFromJSONStrict(t *testing.T) {
+	parse := func(str string) (SchemaSpec, error) {
+		var raw map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(str), &raw))
+		return SchemaSpecFromJSONStrict(raw)
+	}
+	invalid := func(str, message string) {
+		_, err := parse(str)
+		if assert.Error(t, err, str) {
+			assert.Equal(t, message, err.Error())
+		}
+	}
+
+	// accepts a valid spec
+	data, err := json.Marshal(schema.Spec)
+	require.NoError(t, err)
+	var raw map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &raw))
+	spec, err := SchemaSpecFromJSONStrict(raw)
+	if assert.NoError(t, err) {
+		assert.Equal(t, SchemaSpecFromJSON(raw), spec)
+	}
+
+	// rejects malformed entries
+	invalid(`{"nodes": {"doc": {}}}`,
+		`Invalid schema spec: nodes should be an array, got map[doc:map[]]`)
+	invalid(`{"nodes": [["doc", {}], ["text"]]}`,
+		`Invalid node spec #1: expected a [name, spec] pair, got [text]`)
+	invalid(`{"nodes": [["doc", {}, {}]]}`,
+		`Invalid node spec #0: expected a [name, spec] pair, got [doc map[] map[]]`)
+	invalid(`{"nodes": [[42, {}]]}`,
+		`Invalid node spec #0: the name should be a string, got 42`)
+	invalid(`{"nodes": [["doc", "block+"]]}`,
+		`Invalid node spec "doc": the spec should be an object, got block+`)
+	invalid(`{"marks": [["em", {"attrs": []}]]}`,
+		`Invalid mark spec "em": attrs should be an object, got []`)
+	invalid(`{"nodes": [["heading", {"attrs": {"level": 1}}]]}`,
+		`Invalid node spec "heading": attribute "level" should be an object, got 1`)
+	invalid(`{"nodes": [["image", {"inline": "true"}]]}`,
+		`Invalid node spec "image": inline should be a bool, got true`)
+	invalid(`{"marks": [["link", {"rank": "1"}]]}`,
+		`Invalid mark spec "link": rank should be a number, got 1`)
+	invalid(`{"topNode": 1}`,
+		`Invalid schema spec: topNode should be a string, got 1`)
+}
+
+func TestSchemaSpecRoundTrip(t *testing.T) {
+	nodes := append([]*NodeSpec{}, schema.Spec.Nodes...)
+	nodes = append(nodes, &NodeSpec{Key: "mention", Group: "inline", Inline: true, Atom: true, Content: "text*"})
+	spec := &SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks}
+	original, err := NewSchema(spec)
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(spec)
+	assert.NoError(t, err)
+
+	check := func(rebuilt *Schema) {
+		assert.Len(t, rebuilt.Nodes, len(original.Nodes))
+		for _, expected := range original.Nodes {
+			actual, err := rebuilt.NodeType(expected.Name)
+			if !assert.NoError(t, err) {
+				continue
+			}
+			assert.Equal(t, expected.IsAtom(), actual.IsAtom(), expected.Name)
+			assert.Equal(t, expected.IsInline(), actual.IsInline(), expected.Name)
+			assert.Equal(t, expected.DefaultAttrs, actual.DefaultAttrs, expected.Name)
+			if expected.MarkSet == nil {
+				assert.Nil(t, actual.MarkSet, expected.Name)
+				continue
+			}
+			if assert.NotNil(t, actual.MarkSet, expected.Name) {
+				var expectedNames, actualNames []string
+				for _, mt := range *expected.MarkSet {
+					expectedNames = append(expectedNames, mt.Name)
+				}
+				for _, mt := range *actual.MarkSet {
+					actualNames = append(actualNames, mt.Name)
+				}
+				assert.Equal(t, expectedNames, actualNames, expected.Name)
+			}
+		}
+	}
+
+	// survives UnmarshalJSON
+	var unmarshaled SchemaSpec
+	assert.NoError(t, json.Unmarshal(data, &unmarshaled))
+	rebuilt, err := NewSchema(&unmarshaled)
+	assert.NoError(t, err)
+	check(rebuilt)
+
+	// survives SchemaSpecFromJSON
+	var raw map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &raw))
+	fromJSON := SchemaSpecFromJSON(raw)
+	rebuilt, err = NewSchema(&fromJSON)
+	assert.NoError(t, err)
+	check(rebuilt)
+}
+
+func TestSchemaSpecMarshalJSONIsStable(t *testing.T) {
+	spec := &SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "block+"},
+			{Key: "figure", Group: "block", Attrs: map[string]*AttributeSpec{
+				"src":     {},
+				"alt":     {Default: ""},
+				"width":   {Default: 100},
+				"caption": {Default: nil},
+				"style":   {Default: map[string]interface{}{"float": "left", "border": 1, "margin": "auto"}},
+			}},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{
+			{Key: "link", Attrs: map[string]*AttributeSpec{
+				"href":   {},
+				"title":  {Default: nil},
+				"target": {Default: "_blank"},
+				"rel":    {Default: "noopener"},
+			}},
+		},
+	}
+
+	first, err := json.Marshal(spec)
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		again, err := json.Marshal(spec)
+		assert.NoError(t, err)
+		assert.Equal(t, string(first), string(again))
+	}
+
+	// sorts the attribute names
+	assert.Contains(t, string(first), `"attrs":{"alt":{"default":""},"caption":{},"src":{},"style":{"default":{"border":1,"float":"left","margin":"auto"}},"width":{"default":100}}`)
+	assert.Contains(t, string(first), `"attrs":{"href":{},"rel":{"default":"noopener"},"target":{"default":"_blank"},"title":{}}`)
+}
+
+func TestSchemaLinebreakReplacement(t *testing.T) {
+	newSchema := func(replacement *NodeSpec) (*Schema, error) {
+		return NewSchema(&SchemaSpec{
+			Nodes: []*NodeSpec{
+				{Key: "doc", Content: "paragraph+"},
+				{Key: "paragraph", Content: "inline*"},
+				{Key: "text", Group: "inline"},
+				replacement,
+			},
+		})
+	}
+
+	// contributes a newline to the text content
+	s, err := newSchema(&NodeSpec{Key: "hard_break", Group: "inline", Inline: true, LinebreakReplacement: true})
+	assert.NoError(t, err)
+	typ, err := s.NodeType("hard_break")
+	assert.NoError(t, err)
+	assert.Equal(t, typ, s.LinebreakReplacement)
+	hard, err := typ.Create(nil, nil, nil)
+	assert.NoError(t, err)
+	para, err := s.Node("paragraph", nil, []*Node{s.Text("foo"), hard, s.Text("bar")})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo\nbar", para.TextContent())
+
+	// must be an inline leaf node
+	_, err = newSchema(&NodeSpec{Key: "line", Content: "text*", Group: "inline", Inline: true, LinebreakReplacement: true})
+	assert.Error(t, err)
+}
+
+func TestSchemaUnknownGroups(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+	newSchema := func(content, marks string) (*Schema, error) {
+		return NewSchema(&SchemaSpec{
+			Nodes: []*NodeSpec{
+				{Key: "doc", Content: "block+"},
+				{Key: "paragraph", Content: content, Group: "block", Marks: strPtr(marks)},
+				{Key: "text", Group: "inline"},
+			},
+			Marks: []*MarkSpec{
+				{Key: "em", Group: "font"},
+				{Key: "strong", Group: "font", Excludes: strPtr("fnot")},
+			},
+		})
+	}
+
+	// reports a typo in a content expression
+	_, err := newSchema("inlin*", "font")
+	assert.EqualError(t, err, `Invalid content expression for node paragraph: No node type or group "inlin" found (in content expression "inlin*")`)
+
+	// reports a typo in a marks expression
+	_, err = newSchema("inline*", "em fonts")
+	assert.EqualError(t, err, "Invalid marks for node paragraph: Unknown mark type: fonts")
+
+	// reports a typo in an excludes expression
+	_, err = newSchema("inline*", "font")
+	assert.EqualError(t, err, "Invalid excludes for mark strong: Unknown mark type: fnot")
+}
+
+func TestSchemaMissingNodes(t *testing.T) {
+	// reports an empty spec
+	_, err := NewSchema(&SchemaSpec{})
+	assert.EqualError(t, err, "The schema has no node types: it needs at least a top node type (doc) and a 'text' type")
+	_, err = NewSchema(&SchemaSpec{TopNode: "page", Marks: []*MarkSpec{{Key: "em"}}})
+	assert.EqualError(t, err, "The schema has no node types: it needs at least a top node type (page) and a 'text' type")
+
+	// reports the missing top and text nodes
+	_, err = NewSchema(&SchemaSpec{Nodes: []*NodeSpec{{Key: "paragraph"}}})
+	assert.EqualError(t, err, "The schema is missing its top node type (doc) and its 'text' type")
+	_, err = NewSchema(&SchemaSpec{Nodes: []*NodeSpec{{Key: "text"}}})
+	assert.EqualError(t, err, "The schema is missing its top node type (doc)")
+	_, err = NewSchema(&SchemaSpec{Nodes: []*NodeSpec{{Key: "doc"}}})
+	assert.EqualError(t, err, "Every schema needs a 'text' type")
+}
+
+func TestSchemaMarkTypesInGroup(t *testing.T) {
+	s, err := NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "text*"},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{
+			{Key: "em", Group: "font"},
+			{Key: "link"},
+			{Key: "strong", Group: "font bold"},
+		},
+	})
+	assert.NoError(t, err)
+
+	markNames := func(types []*MarkType) []string {
+		var names []string
+		for _, typ := range types {
+			names = append(names, typ.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"em", "strong"}, markNames(s.MarkTypesInGroup("font")))
+	assert.Equal(t, []string{"strong"}, markNames(s.MarkTypesInGroup("bold")))
+	assert.Empty(t, s.MarkTypesInGroup(""))
+}
+
+func TestNodeTypeCreateCheckedInvalidContent(t *testing.T) {
+	paragraph, err := schema.NodeType("paragraph")
+	assert.NoError(t, err)
+
+	_, err = paragraph.CreateChecked(nil, []*Node{p("foo").Node})
+	var contentErr *InvalidContentError
+	if assert.True(t, errors.As(err, &contentErr)) {
+		assert.Equal(t, paragraph, contentErr.Type)
+	}
+	assert.EqualError(t, err, "Invalid content for node paragraph")
+}
+
+func TestMarkTypeIsSpanning(t *testing.T) {
+	notSpanning := false
+	spec := &SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "paragraph+"},
+			{Key: "paragraph", Content: "text*"},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{
+			{Key: "em"},
+			{Key: "comment", Spanning: &notSpanning},
+		},
+	}
+	check := func(s *Schema) {
+		em, err := s.MarkType("em")
+		assert.NoError(t, err)
+		assert.True(t, em.IsSpanning())
+		comment, err := s.MarkType("comment")
+		assert.NoError(t, err)
+		assert.False(t, comment.IsSpanning())
+	}
+
+	// defaults to true
+	original, err := NewSchema(spec)
+	assert.NoError(t, err)
+	check(original)
+
+	data, err := json.Marshal(spec)
+	assert.NoError(t, err)
+
+	// survives UnmarshalJSON
+	var unmarshaled SchemaSpec
+	assert.NoError(t, json.Unmarshal(data, &unmarshaled))
+	rebuilt, err := NewSchema(&unmarshaled)
+	assert.NoError(t, err)
+	check(rebuilt)
+
+	// survives SchemaSpecFromJSON
+	var raw map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &raw))
+	fromJSON := SchemaSpecFromJSON(raw)
+	rebuilt, err = NewSchema(&fromJSON)
+	assert.NoError(t, err)
+	check(rebuilt)
+}
+
+func TestAttributeSpecDefaultFn(t *testing.T) {
+	counter := 0
+	nextID := func() interface{} {
+		counter++
+		return fmt.Sprintf("id%d", counter)
+	}
+	s, err := NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "paragraph+"},
+			{Key: "paragraph", Content: "text*", Attrs: map[string]*AttributeSpec{
+				"id":    {DefaultFn: nextID},
+				"align": {Default: "left", DefaultFn: func() interface{} { return "right" }},
+			}},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{
+			{Key: "comment", Attrs: map[string]*AttributeSpec{"id": {DefaultFn: nextID}}},
+		},
+	})
+	require.NoError(t, err)
+	paragraph, err := s.NodeType("paragraph")
+	require.NoError(t, err)
+
+	// doesn't share the default attributes
+	assert.Nil(t, paragraph.DefaultAttrs)
+
+	// computes a new default for each node
+	a, err := paragraph.Create(nil, nil, nil)
+	require.NoError(t, err)
+	b, err := paragraph.Create(nil, nil, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, a.Attrs["id"], b.Attrs["id"])
+
+	// uses the given value when there is one
+	c, err := paragraph.Create(map[string]interface{}{"id": "mine"}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "mine", c.Attrs["id"])
+
+	// gives precedence to the static default
+	assert.Equal(t, "left", a.Attrs["align"])
+
+	// computes a new default for each mark
+	comment, err := s.MarkType("comment")
+	require.NoError(t, err)
+	assert.NotEqual(t, comment.Create(nil).Attrs["id"], comment.Create(nil).Attrs["id"])
+}
+
+func TestMarkSpecRank(t *testing.T) {
+	first, last := 0, 10
+	spec := &SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "paragraph+"},
+			{Key: "paragraph", Content: "text*"},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{
+			{Key: "em", Rank: &last},
+			{Key: "strong"},
+			{Key: "link", Rank: &first},
+		},
+	}
+	check := func(s *Schema) {
+		em := s.Mark("em")
+		strong := s.Mark("strong")
+		link := s.Mark("link")
+		names := func(set []*Mark) []string {
+			var result []string
+			for _, m := range set {
+				result = append(result, m.Type.Name)
+			}
+			return result
+		}
+
+		// sorts the marks by their explicit rank
+		assert.Equal(t, []string{"link", "strong", "em"}, names(MarkSetFrom([]*Mark{em, strong, link})))
+		assert.Equal(t, []string{"link", "strong", "em"}, names(em.AddToSet(link.AddToSet(strong.AddToSet(nil)))))
+	}
+
+	s, err := NewSchema(spec)
+	assert.NoError(t, err)
+	check(s)
+
+	// defaults to the position in the spec
+	positional, err := NewSchema(&SchemaSpec{Nodes: spec.Nodes, Marks: []*MarkSpec{{Key: "em"}, {Key: "strong"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, positional.Marks[0].Rank)
+	assert.Equal(t, 1, positional.Marks[1].Rank)
+
+	// survives SchemaSpecFromJSON
+	data, err := json.Marshal(spec)
+	assert.NoError(t, err)
+	var raw map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &raw))
+	fromJSON := SchemaSpecFromJSON(raw)
+	s, err = NewSchema(&fromJSON)
+	assert.NoError(t, err)
+	check(s)
+
+	// orders the marks with the same rank by their position in the spec
+	tied, err := NewSchema(&SchemaSpec{Nodes: spec.Nodes, Marks: []*MarkSpec{
+		{Key: "em", Rank: &first},
+		{Key: "strong", Rank: &first},
+	}})
+	require.NoError(t, err)
+	em, strong := tied.Mark("em"), tied.Mark("strong")
+	emFirst := strong.AddToSet(em.AddToSet(nil))
+	strongFirst := em.AddToSet(strong.AddToSet(nil))
+	assert.True(t, SameMarkSet(emFirst, strongFirst))
+	assert.True(t, SameMarkSet(emFirst, MarkSetFrom([]*Mark{strong, em})))
+	assert.Equal(t, "em", strongFirst[0].Type.Name)
+	para, err := tied.NodeType("paragraph")
+	require.NoError(t, err)
+	// so that the text nodes with the same marks are joined
+	a, err := para.Create(nil, []*Node{tied.Text("ab", emFirst)}, nil)
+	require.NoError(t, err)
+	b, err := para.Create(nil, []*Node{tied.Text("a", strongFirst), tied.Text("b", emFirst)}, nil)
+	require.NoError(t, err)
+	assert.True(t, a.Eq(b), "%s != %s", a, b)
+}
+
+func TestNodeTypeCreateAndFillPreferring(t *testing.T) {
+	nodes := append([]*NodeSpec{}, schema.Spec.Nodes...)
+	nodes = append(nodes,
+		&NodeSpec{Key: "section", Content: "(paragraph | heading)+", Group: "block"},
+		&NodeSpec{Key: "chapter", Content: "section+", Group: "block"},
+	)
+	custom, err := NewSchema(&SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks})
+	require.NoError(t, err)
+	section, err := custom.NodeType("section")
+	require.NoError(t, err)
+	chapter, err := custom.NodeType("chapter")
+	require.NoError(t, err)
+	heading, err := custom.NodeType("heading")
+	require.NoError(t, err)
+	paragraph, err := custom.NodeType("paragraph")
+	require.NoError(t, err)
+
+	// uses the first possible type by default
+	node, err := section.CreateAndFill()
+	require.NoError(t, err)
+	assert.Equal(t, paragraph, node.FirstChild().Type)
+
+	// uses the preferred type when it is possible
+	node, err = section.CreateAndFill(nil, nil, nil, heading)
+	require.NoError(t, err)
+	assert.Equal(t, heading, node.FirstChild().Type)
+
+	// uses the preferred type for nested fillers
+	node, err = chapter.CreateAndFill(nil, nil, nil, heading)
+	require.NoError(t, err)
+	assert.Equal(t, heading, node.FirstChild().FirstChild().Type)
+
+	// ignores the preferred type when it is not possible
+	blockquote, err := custom.NodeType("blockquote")
+	require.NoError(t, err)
+	node, err = section.CreateAndFill(nil, nil, nil, blockquote)
+	require.NoError(t, err)
+	assert.Equal(t, paragraph, node.FirstChild().Type)
+
+	// rejects an invalid preferred type
+	_, err = section.CreateAndFill(nil, nil, nil, "heading")
+	assert.Error(t, err)
+}
+
+func TestNodeTypeWithMarksExpression(t *testing.T) {
+	highlightOnly := "highlight"
+	s, err := NewSchema(&SchemaSpec{
+		Nodes: []*NodeSpec{
+			{Key: "doc", Content: "block+"},
+			{Key: "paragraph", Content: "text*", Group: "block"},
+			{Key: "code_block", Content: "text*", Group: "block", Marks: &highlightOnly},
+			{Key: "text"},
+		},
+		Marks: []*MarkSpec{{Key: "em"}, {Key: "highlight"}},
+	})
+	assert.NoError(t, err)
+	codeBlock, err := s.NodeType("code_block")
+	assert.NoError(t, err)
+	highlight := s.Mark("highlight")
+	emphasis := s.Mark("em")
+
+	assert.True(t, codeBlock.AllowsMarkType(highlight.Type))
+	assert.False(t, codeBlock.AllowsMarkType(emphasis.Type))
+	assert.True(t, codeBlock.AllowsMarks([]*Mark{highlight}))
+	assert.False(t, codeBlock.AllowsMarks([]*Mark{emphasis}))
+	assert.False(t, codeBlock.AllowsMarks([]*Mark{highlight, emphasis}))
+
+	marked := []*Node{s.Text("let "), s.Text("x", []*Mark{highlight}), s.Text(" = 1")}
+	assert.True(t, codeBlock.ValidContent(NewFragment(marked)))
+	node, err := codeBlock.CreateChecked(nil, marked)
+	assert.NoError(t, err)
+	assert.Equal(t, "let x = 1", node.TextContent())
+
+	_, err = codeBlock.CreateChecked(nil, []*Node{s.Text("x", []*Mark{emphasis})})
+	assert.EqualError(t, err, "Invalid content for node code_block")
+}
+
+func TestSchemaEmptyDoc(t *testing.T) {
+	empty, err := schema.EmptyDoc()
+	assert.NoError(t, err)
+	assert.True(t, empty.Eq(doc(p()).Node), "%s", empty)
+
+	// uses the top node of the schema
+	s, err := NewSchema(&SchemaSpec{
+		TopNode: "page",
+		Nodes: []*NodeSpec{
+			{Key: "page", Content: "title section*"},
+			{Key: "title", Content: "text*"},
+			{Key: "section", Content: "text*"},
+			{Key: "text"},
+		},
+	})
+	assert.NoError(t, err)
+	empty, err = s.EmptyDoc()
+	assert.NoError(t, err)
+	assert.Equal(t, "page(title)", empty.String())
+}
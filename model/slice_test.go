@@ -1,6 +1,7 @@
 package model_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	. "github.com/cozy/prosemirror-go/model"
@@ -93,3 +94,137 @@ func TestNodeSlice(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, slice.String(), `<blockquote(paragraph("o"), paragraph("bar"))>(2,2)`)
 }
+
+func TestNodeSliceOpen(t *testing.T) {
+	test := func(doc, expect builder.NodeWithTag, openStart, openEnd int) {
+		slice, err := doc.SliceOpen(doc.Tag["a"], doc.Tag["b"], openStart, openEnd)
+		if assert.NoError(t, err) {
+			assert.True(t, slice.Content.Eq(expect.Content), "%s != %s", slice.Content.String(), expect.Content.String())
+			assert.Equal(t, openStart, slice.OpenStart)
+			assert.Equal(t, openEnd, slice.OpenEnd)
+		}
+	}
+	fail := func(doc builder.NodeWithTag, openStart, openEnd int) {
+		_, err := doc.SliceOpen(doc.Tag["a"], doc.Tag["b"], openStart, openEnd)
+		assert.Error(t, err)
+	}
+
+	// can keep the list around some text
+	test(doc(ul(li(p("a<a>b<b>c")))), doc(ul(li(p("b")))), 3, 3)
+
+	// can keep only some of the parents
+	test(doc(ul(li(p("a<a>b<b>c")))), doc(li(p("b"))), 2, 2)
+
+	// gives the same result as Slice with the natural open depths
+	test(doc(p("a<a>b"), p("c<b>d")), doc(p("b"), p("c")), 1, 1)
+
+	// can have different open depths on both sides
+	test(doc(blockquote(p("a<a>b")), p("c<b>d")), doc(blockquote(p("b")), p("c")), 2, 1)
+
+	// fails when the slice is opened deeper than the positions
+	fail(doc(p("a<a>b<b>c")), 2, 2)
+
+	// fails when the open depths don't cut at the same level
+	fail(doc(ul(li(p("a<a>b<b>c")))), 3, 2)
+
+	// fails when the open depths are too small for the positions
+	fail(doc(p("a<a>b"), p("c<b>d")), 0, 0)
+
+	// fails on negative open depths
+	fail(doc(p("a<a>b<b>c")), -1, -1)
+}
+
+func TestSliceFromJSON(t *testing.T) {
+	fromJSON := func(raw string) (*Slice, error) {
+		var obj interface{}
+		if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+			return nil, err
+		}
+		return SliceFromJSON(schema, obj)
+	}
+
+	// round-trips a slice
+	slice, err := doc(blockquote(p("foobar")), p("baz")).Slice(5, 12)
+	assert.NoError(t, err)
+	data, err := json.Marshal(slice.ToJSON())
+	assert.NoError(t, err)
+	actual, err := fromJSON(string(data))
+	assert.NoError(t, err)
+	assert.True(t, actual.Eq(slice), "%s != %s", actual, slice)
+
+	// accepts null
+	actual, err = fromJSON(`null`)
+	assert.NoError(t, err)
+	assert.True(t, actual.Eq(EmptySlice))
+
+	// accepts open depths up to the depth of the content
+	_, err = fromJSON(`{"content":[{"type":"blockquote","content":[{"type":"paragraph"}]}],"openStart":2,"openEnd":2}`)
+	assert.NoError(t, err)
+
+	// rejects an over-deep openStart
+	_, err = fromJSON(`{"content":[{"type":"paragraph","content":[{"type":"text","text":"foo"}]}],"openStart":2}`)
+	assert.Error(t, err)
+
+	// rejects an over-deep openEnd
+	_, err = fromJSON(`{"content":[{"type":"paragraph"},{"type":"horizontal_rule"}],"openEnd":1}`)
+	assert.Error(t, err)
+
+	// rejects negative open depths
+	_, err = fromJSON(`{"content":[{"type":"paragraph"}],"openStart":-1}`)
+	assert.Error(t, err)
+
+	// rejects malformed input
+	_, err = fromJSON(`[1, 2]`)
+	assert.Error(t, err)
+	_, err = fromJSON(`{"content":[{"type":"paragraph"}],"openStart":"1"}`)
+	assert.Error(t, err)
+}
+
+func TestSliceAppend(t *testing.T) {
+	slice := func(node builder.NodeWithTag, from, to int) *Slice {
+		s, err := node.Slice(from, to, true)
+		assert.NoError(t, err)
+		return s
+	}
+	appendSlices := func(a, b, expected *Slice) {
+		actual, err := a.Append(b)
+		if assert.NoError(t, err) {
+			assert.True(t, actual.Eq(expected), "%s != %s", actual, expected)
+		}
+	}
+	fragment := func(nodes ...builder.NodeWithTag) *Fragment {
+		var content []*Node
+		for _, n := range nodes {
+			content = append(content, n.Node)
+		}
+		return NewFragment(content)
+	}
+
+	// places a list slice open at its end after a paragraph slice
+	appendSlices(slice(doc(p("hello")), 2, 6), slice(doc(ul(li(p("one")), li(p("two")))), 0, 12),
+		NewSlice(fragment(p("ello"), ul(li(p("one")), li(p("tw")))), 1, 3))
+
+	// joins the paragraphs open on both sides of the boundary
+	appendSlices(slice(doc(p("hello")), 1, 3), slice(doc(p("world")), 1, 4),
+		NewSlice(fragment(p("hewor")), 1, 1))
+
+	// joins list items
+	appendSlices(slice(doc(ul(li(p("one")))), 0, 4), slice(doc(ul(li(p("one")), li(p("two")))), 4, 16),
+		NewSlice(fragment(ul(li(p("one")), li(p("two")))), 0, 0))
+
+	// doesn't join nodes with a different markup
+	appendSlices(slice(doc(p("hello")), 1, 3), slice(doc(h1("world")), 1, 4),
+		NewSlice(fragment(p("he"), h1("wor")), 1, 1))
+
+	// only joins down to the smaller open depth
+	appendSlices(slice(doc(blockquote(p("foo"))), 0, 3), slice(doc(blockquote(p("bar"))), 1, 7),
+		NewSlice(fragment(blockquote(p("f"), p("bar"))), 0, 0))
+
+	// handles empty slices
+	appendSlices(EmptySlice, slice(doc(p("hello")), 1, 3), slice(doc(p("hello")), 1, 3))
+	appendSlices(slice(doc(p("hello")), 1, 3), EmptySlice, slice(doc(p("hello")), 1, 3))
+
+	// fails when the open depths are not consistent with the content
+	_, err := NewSlice(fragment(p("a")), 0, 2).Append(slice(doc(p("b")), 1, 2))
+	assert.EqualError(t, err, "Invalid openEnd 2 for Slice.append (max 1)")
+}
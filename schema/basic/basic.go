@@ -42,7 +42,7 @@ var Nodes = []*model.NodeSpec{
 
 	// A code listing. Disallows marks or non-text inline nodes by default.
 	// Represented as a <pre> element with a <code> element inside of it.
-	{Key: "code_block", Content: "text*", Marks: &empty, Group: "block"},
+	{Key: "code_block", Content: "text*", Marks: &empty, Group: "block", Whitespace: "pre"},
 
 	// The text node.
 	{Key: "text", Group: "inline"},
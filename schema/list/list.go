@@ -28,6 +28,14 @@ var (
 )
 
 func add(obj, props model.NodeSpec) *model.NodeSpec {
+	if obj.Attrs != nil {
+		attrs := make(map[string]*model.AttributeSpec, len(obj.Attrs))
+		for name, attr := range obj.Attrs {
+			cpy := *attr
+			attrs[name] = &cpy
+		}
+		obj.Attrs = attrs
+	}
 	if props.Content != "" {
 		obj.Content = props.Content
 	}
@@ -46,9 +54,14 @@ func add(obj, props model.NodeSpec) *model.NodeSpec {
 // should have a shape like "paragraph block*" or "paragraph (ordered_list |
 // bullet_list)*". listGroup can be given to assign a group name to the list
 // node types, for example "block".
+//
+// The given slice is not modified, and the list node specs are new for each
+// call, so several schemas can be built from the same base nodes.
 func AddListNodes(nodes []*model.NodeSpec, itemContent, listGroup string) []*model.NodeSpec {
+	result := make([]*model.NodeSpec, len(nodes), len(nodes)+3)
+	copy(result, nodes)
 	return append(
-		nodes,
+		result,
 		add(orderedList, model.NodeSpec{Content: "list_item+", Group: listGroup}),
 		add(bulletList, model.NodeSpec{Content: "list_item+", Group: listGroup}),
 		add(listItem, model.NodeSpec{Content: itemContent}),
@@ -0,0 +1,105 @@
+package list_test
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/schema/basic"
+	"github.com/cozy/prosemirror-go/schema/list"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddListNodesIndependence(t *testing.T) {
+	base := make([]*model.NodeSpec, len(basic.Schema.Spec.Nodes), len(basic.Schema.Spec.Nodes)+3)
+	copy(base, basic.Schema.Spec.Nodes)
+
+	first := list.AddListNodes(base, "paragraph block*", "block")
+	second := list.AddListNodes(base, "paragraph+", "block")
+
+	// doesn't modify the given nodes
+	assert.Len(t, base, len(basic.Schema.Spec.Nodes))
+
+	// builds different list specs for each call
+	assert.Equal(t, "paragraph block*", first[len(first)-1].Content)
+	assert.Equal(t, "paragraph+", second[len(second)-1].Content)
+
+	firstOL := first[len(first)-3]
+	secondOL := second[len(second)-3]
+	firstOL.Attrs["order"].Default = 5.0
+	firstOL.Attrs["reversed"] = &model.AttributeSpec{Default: false}
+	assert.Equal(t, 1.0, secondOL.Attrs["order"].Default)
+	assert.NotContains(t, secondOL.Attrs, "reversed")
+
+	// builds independent schemas
+	firstSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: first, Marks: basic.Schema.Spec.Marks})
+	require.NoError(t, err)
+	secondSchema, err := model.NewSchema(&model.SchemaSpec{Nodes: second, Marks: basic.Schema.Spec.Marks})
+	require.NoError(t, err)
+	ol, err := firstSchema.NodeType("ordered_list")
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, ol.DefaultAttrs["order"])
+	ol, err = secondSchema.NodeType("ordered_list")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, ol.DefaultAttrs["order"])
+}
+
+func TestListItemDefaultContent(t *testing.T) {
+	nodes := list.AddListNodes(basic.Schema.Spec.Nodes, "paragraph block*", "block")
+	s, err := model.NewSchema(&model.SchemaSpec{Nodes: nodes, Marks: basic.Schema.Spec.Marks})
+	require.NoError(t, err)
+
+	// is an empty paragraph for a list item
+	item, err := s.NodeType("list_item")
+	require.NoError(t, err)
+	content, err := item.DefaultContent()
+	require.NoError(t, err)
+	if assert.Equal(t, 1, content.ChildCount()) {
+		assert.Equal(t, "paragraph", content.FirstChild().Type.Name)
+		assert.Equal(t, 0, content.FirstChild().Content.Size)
+	}
+	created, err := item.CreateAndFill()
+	require.NoError(t, err)
+	assert.True(t, created.Content.Eq(content))
+
+	// is filled recursively for a list
+	bulletList, err := s.NodeType("bullet_list")
+	require.NoError(t, err)
+	content, err = bulletList.DefaultContent()
+	require.NoError(t, err)
+	assert.Equal(t, "list_item(paragraph)", content.FirstChild().String())
+
+	// is empty for a paragraph
+	paragraph, err := s.NodeType("paragraph")
+	require.NoError(t, err)
+	content, err = paragraph.DefaultContent()
+	require.NoError(t, err)
+	assert.Equal(t, 0, content.Size)
+}
+
+func TestSchemaTypesInGroup(t *testing.T) {
+	nodes := list.AddListNodes(basic.Schema.Spec.Nodes, "paragraph block*", "block")
+	s, err := model.NewSchema(&model.SchemaSpec{Nodes: nodes, Marks: basic.Schema.Spec.Marks})
+	require.NoError(t, err)
+
+	names := func(types []*model.NodeType) []string {
+		var result []string
+		for _, typ := range types {
+			result = append(result, typ.Name)
+		}
+		return result
+	}
+
+	// lists the block node types, in the order of the schema
+	assert.Equal(t, []string{
+		"paragraph", "blockquote", "horizontal_rule", "heading", "code_block",
+		"ordered_list", "bullet_list",
+	}, names(s.NodeTypesInGroup("block")))
+
+	// lists the inline node types
+	assert.Equal(t, []string{"text", "image", "hard_break"}, names(s.NodeTypesInGroup("inline")))
+
+	// returns nothing for an unknown group
+	assert.Empty(t, s.NodeTypesInGroup("unknown"))
+	assert.Empty(t, s.MarkTypesInGroup("unknown"))
+}
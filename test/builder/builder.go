@@ -206,11 +206,15 @@ func mark(typ *model.MarkType, attrs map[string]interface{}) MarkBuilder {
 	return func(args ...interface{}) Result {
 		myAttrs, myArgs := takeAttrs(attrs, args)
 		mark := typ.Create(myAttrs)
+		// The marks of the inner nodes win: a mark that excludes them (like a
+		// link in another link) is not added, but marks that can coexist
+		// with them are.
 		f := func(n *model.Node) *model.Node {
-			if mark.Type.IsInSet(n.Marks) != nil {
-				return n
+			newMarks := mark.AddToSet(n.Marks)
+			if len(newMarks) > len(n.Marks) {
+				return n.Mark(newMarks)
 			}
-			return n.Mark(mark.AddToSet(n.Marks))
+			return n
 		}
 		result := flatten(typ.Schema, myArgs, f)
 		return Result{
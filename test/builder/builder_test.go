@@ -0,0 +1,48 @@
+package builder_test
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	. "github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkBuilderNested(t *testing.T) {
+	foo := map[string]interface{}{"href": "foo"}
+	bar := map[string]interface{}{"href": "bar"}
+
+	// builds a link nested in another link as adjacent links
+	actual := Doc(P("a ", A(foo, "big ", A(bar, "nested"), " link")))
+	expected := Doc(P("a ", A(foo, "big "), A(bar, "nested"), A(foo, " link")))
+	nodes := actual.FirstChild().Content.Content
+	if assert.Len(t, nodes, 4) {
+		assert.Equal(t, "bar", nodes[2].Marks[0].Attrs["href"])
+	}
+	assert.True(t, actual.Eq(expected.Node), "%s != %s\n", actual.String(), expected.String())
+
+	// keeps both marks when the mark type doesn't exclude itself
+	none := ""
+	schema, err := model.NewSchema(&model.SchemaSpec{
+		Nodes: []*model.NodeSpec{
+			{Key: "doc", Content: "paragraph+"},
+			{Key: "paragraph", Content: "text*"},
+			{Key: "text"},
+		},
+		Marks: []*model.MarkSpec{
+			{Key: "comment", Attrs: map[string]*model.AttributeSpec{"id": {}}, Excludes: &none},
+		},
+	})
+	require.NoError(t, err)
+	builders := Builders(schema, nil)
+	doc := builders["doc"].(NodeBuilder)
+	p := builders["paragraph"].(NodeBuilder)
+	comment := builders["comment"].(MarkBuilder)
+	node := doc(p(comment(map[string]interface{}{"id": 1}, "a", comment(map[string]interface{}{"id": 2}, "b"))))
+	inner := node.FirstChild().LastChild()
+	if assert.Len(t, inner.Marks, 2) {
+		assert.Equal(t, 2, inner.Marks[0].Attrs["id"])
+		assert.Equal(t, 1, inner.Marks[1].Attrs["id"])
+	}
+}
@@ -0,0 +1,73 @@
+package transform
+
+import (
+	"errors"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// DocAttrStep updates an attribute of the top-level node of the document,
+// which can't be targeted with a SetAttrsStep, as it has no position.
+type DocAttrStep struct {
+	Attr  string
+	Value interface{}
+}
+
+// NewDocAttrStep is a constructor for DocAttrStep.
+func NewDocAttrStep(attr string, value interface{}) *DocAttrStep {
+	return &DocAttrStep{Attr: attr, Value: value}
+}
+
+// Apply is a method of the Step interface.
+func (s *DocAttrStep) Apply(doc *model.Node) StepResult {
+	attrs := map[string]interface{}{}
+	for k, v := range doc.Attrs {
+		attrs[k] = v
+	}
+	attrs[s.Attr] = s.Value
+	updated, err := doc.Type.Create(attrs, doc.Content, doc.Marks)
+	if err != nil {
+		return FailWithError(err)
+	}
+	return OK(updated)
+}
+
+// GetMap is a method of the Step interface.
+func (s *DocAttrStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Invert is a method of the Step interface.
+func (s *DocAttrStep) Invert(doc *model.Node) Step {
+	return NewDocAttrStep(s.Attr, doc.Attrs[s.Attr])
+}
+
+// Map is a method of the Step interface.
+func (s *DocAttrStep) Map(mapping Mappable) Step {
+	return s
+}
+
+// Merge is a method of the Step interface.
+func (s *DocAttrStep) Merge(other Step) (Step, bool) {
+	return nil, false
+}
+
+// ToJSON is a method of the Step interface.
+func (s *DocAttrStep) ToJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"stepType": "docAttr",
+		"attr":     s.Attr,
+		"value":    s.Value,
+	}
+}
+
+// DocAttrStepFromJSON builds a DocAttrStep from a JSON representation.
+func DocAttrStepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error) {
+	attr, ok := obj["attr"].(string)
+	if !ok {
+		return nil, errors.New("Invalid input for DocAttrStep.fromJSON")
+	}
+	return NewDocAttrStep(attr, obj["value"]), nil
+}
+
+var _ Step = &DocAttrStep{}
@@ -0,0 +1,43 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetDocAttribute(t *testing.T) {
+	out := schemaWithAttrs("doc", map[string]*model.AttributeSpec{"theme": {Default: "light"}})
+	themeSchema := out["schema"].(*model.Schema)
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+
+	before := doc(p("foo"), p("bar")).Node
+	tr := NewTransform(before)
+	require.NoError(t, tr.SetDocAttribute("theme", "dark"))
+	assert.Equal(t, "dark", tr.Doc.Attrs["theme"])
+	assert.True(t, tr.Doc.Content.Eq(before.Content))
+	assert.Equal(t, "light", before.Attrs["theme"])
+
+	// the positions are not changed
+	assert.Equal(t, 5, tr.Mapping.Map(5))
+
+	// can be inverted
+	inverted := NewTransform(tr.Doc)
+	for _, step := range tr.Invert() {
+		require.NoError(t, inverted.Step(step))
+	}
+	assert.Equal(t, "light", inverted.Doc.Attrs["theme"])
+	assert.True(t, inverted.Doc.Eq(before), "%s != %s", inverted.Doc, before)
+
+	// round-trips through JSON
+	step := NewDocAttrStep("theme", "dark")
+	fromJSON, err := StepFromJSON(themeSchema, step.ToJSON())
+	require.NoError(t, err)
+	assert.Equal(t, step, fromJSON)
+	_, err = StepFromJSON(themeSchema, map[string]interface{}{"stepType": "docAttr"})
+	assert.Error(t, err)
+}
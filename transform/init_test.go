@@ -1,10 +1,60 @@
 package transform
 
-import "github.com/cozy/prosemirror-go/test/builder"
+import (
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+)
 
 var (
 	schema = builder.Schema
 	doc    = builder.Doc
 	p      = builder.P
 	h1     = builder.H1
+	ol     = builder.Ol
+	li     = builder.Li
+	em     = builder.Em
+	strong = builder.Strong
+	a      = builder.A
+	img    = builder.Img
 )
+
+// schemaWithAttrs returns the builders for a copy of the test schema, where
+// the node type nodeKey has the given attributes. The schema is in the
+// "schema" entry.
+func schemaWithAttrs(nodeKey string, attrs map[string]*model.AttributeSpec) map[string]interface{} {
+	nodes := append([]*model.NodeSpec{}, schema.Spec.Nodes...)
+	for i, node := range nodes {
+		if node.Key == nodeKey {
+			cpy := *node
+			cpy.Attrs = attrs
+			nodes[i] = &cpy
+		}
+	}
+	s, err := model.NewSchema(&model.SchemaSpec{Nodes: nodes, Marks: schema.Spec.Marks})
+	if err != nil {
+		panic(err)
+	}
+	return builder.Builders(s, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+}
+
+// commentSchema returns the builders for a copy of the test schema with a
+// comment mark, which is also allowed on the children of the document, as a
+// node mark. The schema is in the "schema" entry.
+func commentSchema() map[string]interface{} {
+	nodes := append([]*model.NodeSpec{}, schema.Spec.Nodes...)
+	comment := "comment"
+	for i, node := range nodes {
+		if node.Key == "doc" {
+			cpy := *node
+			cpy.Marks = &comment
+			nodes[i] = &cpy
+		}
+	}
+	marks := append([]*model.MarkSpec{}, schema.Spec.Marks...)
+	marks = append(marks, &model.MarkSpec{Key: "comment", Attrs: map[string]*model.AttributeSpec{"id": {Default: 1.0}}})
+	s, err := model.NewSchema(&model.SchemaSpec{Nodes: nodes, Marks: marks})
+	if err != nil {
+		panic(err)
+	}
+	return builder.Builders(s, map[string]builder.Spec{"p": {"nodeType": "paragraph"}})
+}
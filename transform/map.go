@@ -1,6 +1,9 @@
 package transform
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // Mappable is an interface. There are several things that positions can be
 // mapped through. Such objects conform to this interface.
@@ -126,6 +129,27 @@ func (sm *StepMap) _map(pos, assoc int, simple bool) interface{} {
 	return NewMapResult(pos + diff)
 }
 
+// ForEach calls the given function on each of the changed ranges included in
+// this map.
+func (sm *StepMap) ForEach(fn func(oldStart, oldEnd, newStart, newEnd int)) {
+	oldIndex, newIndex := 1, 2
+	if sm.Inverted {
+		oldIndex, newIndex = 2, 1
+	}
+	diff := 0
+	for i := 0; i < len(sm.Ranges); i += 3 {
+		start := sm.Ranges[i]
+		oldStart, newStart := start, start+diff
+		if sm.Inverted {
+			oldStart, newStart = start-diff, start
+		}
+		oldSize := sm.Ranges[i+oldIndex]
+		newSize := sm.Ranges[i+newIndex]
+		fn(oldStart, oldStart+oldSize, newStart, newStart+newSize)
+		diff += newSize - oldSize
+	}
+}
+
 // Invert creates an inverted version of this map. The result can be used to
 // map positions in the post-step document to the pre-step document.
 func (sm *StepMap) Invert() *StepMap {
@@ -141,7 +165,158 @@ func (sm *StepMap) String() string {
 	return fmt.Sprintf("%s%v", prefix, sm.Ranges)
 }
 
+type stepMapJSON struct {
+	Ranges   []int `json:"ranges"`
+	Inverted bool  `json:"inverted"`
+}
+
+// MarshalJSON creates a JSON representation of the StepMap, as an object with
+// the ranges and inverted properties.
+func (sm StepMap) MarshalJSON() ([]byte, error) {
+	ranges := sm.Ranges
+	if ranges == nil {
+		ranges = []int{}
+	}
+	return json.Marshal(stepMapJSON{Ranges: ranges, Inverted: sm.Inverted})
+}
+
+// UnmarshalJSON reads a StepMap from its JSON representation.
+func (sm *StepMap) UnmarshalJSON(data []byte) error {
+	var raw stepMapJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.Ranges)%3 != 0 {
+		return fmt.Errorf("Invalid ranges for StepMap: %v", raw.Ranges)
+	}
+	if len(raw.Ranges) == 0 {
+		raw.Ranges = nil
+	}
+	sm.Ranges = raw.Ranges
+	sm.Inverted = raw.Inverted
+	return nil
+}
+
 // EmptyStepMap is an empty StepMap.
 var EmptyStepMap = NewStepMap(nil)
 
-var _ Mappable = &StepMap{}
+// Mapping represents a pipeline of zero or more step maps. Positions are
+// mapped through each of them in turn: the inverted versions of earlier steps
+// are not tracked, so a position deleted by a step is not recovered by its
+// inverse.
+type Mapping struct {
+	// The step maps in this mapping.
+	Maps []*StepMap
+	// The starting position in the maps array, used when map or mapResult is
+	// called.
+	From int
+	// The end position in the maps array.
+	To int
+}
+
+// NewMapping creates a new mapping with the given position maps. from and to
+// can be given to restrict the part of the maps that are used.
+//
+// :: (?[StepMap], ?number, ?number)
+func NewMapping(maps []*StepMap, args ...int) *Mapping {
+	from := 0
+	if len(args) > 0 {
+		from = args[0]
+	}
+	to := len(maps)
+	if len(args) > 1 {
+		to = args[1]
+	}
+	return &Mapping{Maps: maps, From: from, To: to}
+}
+
+type mappingJSON struct {
+	Maps []*StepMap `json:"maps"`
+	From int        `json:"from"`
+	To   int        `json:"to"`
+}
+
+// MarshalJSON creates a JSON representation of the Mapping, as an object with
+// the maps, from and to properties.
+func (m Mapping) MarshalJSON() ([]byte, error) {
+	maps := m.Maps
+	if maps == nil {
+		maps = []*StepMap{}
+	}
+	return json.Marshal(mappingJSON{Maps: maps, From: m.From, To: m.To})
+}
+
+// UnmarshalJSON reads a Mapping from its JSON representation.
+func (m *Mapping) UnmarshalJSON(data []byte) error {
+	var raw mappingJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.From < 0 || raw.To < raw.From || raw.To > len(raw.Maps) {
+		return fmt.Errorf("Invalid range for Mapping: %d-%d", raw.From, raw.To)
+	}
+	for i, sm := range raw.Maps {
+		if sm == nil {
+			return fmt.Errorf("Invalid step map #%d for Mapping", i)
+		}
+	}
+	if len(raw.Maps) == 0 {
+		raw.Maps = nil
+	}
+	m.Maps = raw.Maps
+	m.From = raw.From
+	m.To = raw.To
+	return nil
+}
+
+// Slice creates a mapping that maps only through a part of this one.
+func (m *Mapping) Slice(args ...int) *Mapping {
+	from := 0
+	if len(args) > 0 {
+		from = args[0]
+	}
+	to := len(m.Maps)
+	if len(args) > 1 {
+		to = args[1]
+	}
+	return NewMapping(m.Maps, from, to)
+}
+
+// AppendMap adds a step map to the end of this mapping.
+func (m *Mapping) AppendMap(sm *StepMap) {
+	m.Maps = append(m.Maps, sm)
+	m.To = len(m.Maps)
+}
+
+// AppendMapping adds all the step maps in a given mapping to this one.
+func (m *Mapping) AppendMapping(mapping *Mapping) {
+	for i := mapping.From; i < mapping.To; i++ {
+		m.AppendMap(mapping.Maps[i])
+	}
+}
+
+// Map is part of the Mappable interface.
+func (m *Mapping) Map(pos int, assoc ...int) int {
+	for i := m.From; i < m.To; i++ {
+		pos = m.Maps[i].Map(pos, assoc...)
+	}
+	return pos
+}
+
+// MapResult is part of the Mappable interface.
+func (m *Mapping) MapResult(pos int, assoc ...int) *MapResult {
+	deleted := false
+	for i := m.From; i < m.To; i++ {
+		result := m.Maps[i].MapResult(pos, assoc...)
+		if result.Deleted {
+			deleted = true
+		}
+		pos = result.Pos
+	}
+	return NewMapResult(pos, deleted)
+}
+
+var (
+	_ Mappable = &StepMap{}
+	_ Mappable = &Mapping{}
+)
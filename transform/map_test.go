@@ -0,0 +1,81 @@
+package transform
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStepMapJSON(t *testing.T) {
+	sm := NewStepMap([]int{2, 4, 0, 10, 0, 3, 20, 2, 5}).Invert()
+
+	raw, err := json.Marshal(sm)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ranges":[2,4,0,10,0,3,20,2,5],"inverted":true}`, string(raw))
+
+	var decoded StepMap
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	assert.Equal(t, sm, &decoded)
+	for pos := 0; pos < 30; pos++ {
+		assert.Equal(t, sm.MapResult(pos, -1), decoded.MapResult(pos, -1), pos)
+		assert.Equal(t, sm.MapResult(pos, 1), decoded.MapResult(pos, 1), pos)
+	}
+
+	// writes the empty ranges as an array
+	raw, err = json.Marshal(EmptyStepMap)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ranges":[],"inverted":false}`, string(raw))
+
+	// rejects ranges that are not triplets
+	assert.Error(t, json.Unmarshal([]byte(`{"ranges":[1,2]}`), &decoded))
+}
+
+func TestStepMapForEach(t *testing.T) {
+	collect := func(sm *StepMap) [][4]int {
+		var ranges [][4]int
+		sm.ForEach(func(oldStart, oldEnd, newStart, newEnd int) {
+			ranges = append(ranges, [4]int{oldStart, oldEnd, newStart, newEnd})
+		})
+		return ranges
+	}
+
+	// reports the ranges of a replace-around step
+	testDoc := doc(p("Ma super note")).Node
+	frag := model.NewFragment([]*model.Node{h1().Node})
+	step := NewReplaceAroundStep(0, 15, 1, 14, model.NewSlice(frag, 0, 0), 1, true)
+	assert.Empty(t, step.Apply(testDoc).Failed)
+	assert.Equal(t, [][4]int{{0, 1, 0, 1}, {14, 15, 14, 15}}, collect(step.GetMap()))
+
+	// takes the size changes of the previous ranges into account
+	sm := NewStepMap([]int{2, 4, 0, 10, 0, 3})
+	assert.Equal(t, [][4]int{{2, 6, 2, 2}, {10, 10, 6, 9}}, collect(sm))
+
+	// reports the ranges of an inverted map
+	assert.Equal(t, [][4]int{{2, 2, 2, 6}, {6, 9, 10, 10}}, collect(sm.Invert()))
+
+	// doesn't report anything for an empty map
+	assert.Empty(t, collect(EmptyStepMap))
+}
+
+func TestMappingJSON(t *testing.T) {
+	tr := NewTransform(doc(p("hello"), p("world")).Node)
+	require.NoError(t, tr.Delete(2, 4))
+	require.NoError(t, tr.Join(5))
+	mapping := NewMapping(append(tr.Mapping.Maps, tr.Mapping.Maps[0].Invert()), 0, 2)
+
+	raw, err := json.Marshal(mapping)
+	require.NoError(t, err)
+	var decoded Mapping
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	assert.Equal(t, mapping, &decoded)
+	for pos := 0; pos <= tr.Before().Content.Size; pos++ {
+		assert.Equal(t, mapping.MapResult(pos), decoded.MapResult(pos), pos)
+	}
+
+	// rejects an invalid range of maps
+	assert.Error(t, json.Unmarshal([]byte(`{"maps":[],"from":0,"to":1}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`{"maps":[null],"from":0,"to":1}`), &decoded))
+}
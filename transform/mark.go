@@ -0,0 +1,146 @@
+package transform
+
+import (
+	"fmt"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+type matchedMark struct {
+	style *model.Mark
+	from  int
+	to    int
+	step  int
+}
+
+// AddMark adds the given mark to the inline content between from and to, and
+// to the non-inline nodes that are entirely in this range, as node marks. The
+// marks that are excluded by this mark are removed, and the nodes whose parent
+// doesn't allow the mark are left untouched.
+func (tr *Transform) AddMark(from, to int, mark *model.Mark) error {
+	var removed, added []*matchedMark
+	var removing, adding *matchedMark
+	var nodeMarks []int
+	tr.Doc.NodesBetween(from, to, func(node *model.Node, pos int, parent *model.Node, _ int) bool {
+		if !node.IsInline() {
+			if pos >= from && pos+node.NodeSize() <= to &&
+				!mark.IsInSet(node.Marks) && parent.Type.AllowsMarkType(mark.Type) {
+				nodeMarks = append(nodeMarks, pos)
+			}
+			return true
+		}
+		marks := node.Marks
+		if mark.IsInSet(marks) || !parent.Type.AllowsMarkType(mark.Type) {
+			return true
+		}
+		start, end := pos, pos+node.NodeSize()
+		if start < from {
+			start = from
+		}
+		if end > to {
+			end = to
+		}
+		newSet := mark.AddToSet(marks)
+		for _, m := range marks {
+			if m.IsInSet(newSet) {
+				continue
+			}
+			if removing != nil && removing.to == start && removing.style.Eq(m) {
+				removing.to = end
+			} else {
+				removing = &matchedMark{style: m, from: start, to: end}
+				removed = append(removed, removing)
+			}
+		}
+		if adding != nil && adding.to == start {
+			adding.to = end
+		} else {
+			adding = &matchedMark{style: mark, from: start, to: end}
+			added = append(added, adding)
+		}
+		return true
+	})
+	for _, m := range removed {
+		if err := tr.Step(NewRemoveMarkStep(m.from, m.to, m.style)); err != nil {
+			return err
+		}
+	}
+	for _, m := range added {
+		if err := tr.Step(NewAddMarkStep(m.from, m.to, m.style)); err != nil {
+			return err
+		}
+	}
+	for _, pos := range nodeMarks {
+		if err := tr.Step(NewAddNodeMarkStep(pos, mark)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveMark removes marks from inline nodes between from and to. When mark
+// is a single *model.Mark, remove precisely that mark. When it is a
+// *model.MarkType, remove all marks of that type, whatever their attributes.
+// When it is nil, remove all marks of any type.
+func (tr *Transform) RemoveMark(from, to int, mark interface{}) error {
+	switch mark.(type) {
+	case *model.MarkType, *model.Mark, nil:
+	default:
+		return fmt.Errorf("Invalid mark for RemoveMark: %v", mark)
+	}
+	var matched []*matchedMark
+	step := 0
+	tr.Doc.NodesBetween(from, to, func(node *model.Node, pos int, _ *model.Node, _ int) bool {
+		if !node.IsInline() {
+			return true
+		}
+		step++
+		var toRemove []*model.Mark
+		switch m := mark.(type) {
+		case *model.MarkType:
+			for _, found := range node.Marks {
+				if found.Type == m {
+					toRemove = append(toRemove, found)
+				}
+			}
+		case *model.Mark:
+			if m.IsInSet(node.Marks) {
+				toRemove = []*model.Mark{m}
+			}
+		case nil:
+			toRemove = node.Marks
+		}
+		if len(toRemove) == 0 {
+			return true
+		}
+		end := pos + node.NodeSize()
+		if end > to {
+			end = to
+		}
+		for _, style := range toRemove {
+			var found *matchedMark
+			for _, m := range matched {
+				if m.step == step-1 && style.Eq(m.style) {
+					found = m
+				}
+			}
+			if found != nil {
+				found.to = end
+				found.step = step
+			} else {
+				start := pos
+				if start < from {
+					start = from
+				}
+				matched = append(matched, &matchedMark{style: style, from: start, to: end, step: step})
+			}
+		}
+		return true
+	})
+	for _, m := range matched {
+		if err := tr.Step(NewRemoveMarkStep(m.from, m.to, m.style)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
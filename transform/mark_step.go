@@ -2,30 +2,42 @@ package transform
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/cozy/prosemirror-go/model"
 )
 
 type mapFn func(node, parent *model.Node) *model.Node
 
+// mapFragment calls f on the inline nodes of the fragment, recursively. The
+// nodes for which f returns the same pointer are reused, and so are the
+// subtrees where nothing has changed (including the fragment itself).
 func mapFragment(fragment *model.Fragment, f mapFn, parent *model.Node) (*model.Fragment, error) {
 	var mapped []*model.Node
-	for i := 0; i < fragment.ChildCount(); i++ {
-		child, err := fragment.Child(i)
-		if err != nil {
-			return nil, err
-		}
+	for i, child := range fragment.Content {
+		orig := child
 		if child.Content.Size > 0 {
 			copied, err := mapFragment(child.Content, f, child)
 			if err != nil {
 				return nil, err
 			}
-			child = child.Copy(copied)
+			if copied != child.Content {
+				child = child.Copy(copied)
+			}
 		}
 		if child.IsInline() {
 			child = f(child, parent)
 		}
-		mapped = append(mapped, child)
+		if mapped == nil && child != orig {
+			mapped = make([]*model.Node, i, len(fragment.Content))
+			copy(mapped, fragment.Content[:i])
+		}
+		if mapped != nil {
+			mapped = append(mapped, child)
+		}
+	}
+	if mapped == nil {
+		return fragment, nil
 	}
 	content, err := model.FragmentFrom(mapped)
 	if err != nil {
@@ -50,11 +62,11 @@ func NewAddMarkStep(from, to int, mark *model.Mark) *AddMarkStep {
 func (s *AddMarkStep) Apply(doc *model.Node) StepResult {
 	oldSlice, err := doc.Slice(s.From, s.To)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	dFrom, err := doc.Resolve(s.From)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	parent := dFrom.Node(dFrom.SharedDepth(s.To))
 	fragment, err := mapFragment(oldSlice.Content, func(node, parent *model.Node) *model.Node {
@@ -64,7 +76,7 @@ func (s *AddMarkStep) Apply(doc *model.Node) StepResult {
 		return node.Mark(s.Mark.AddToSet(node.Marks))
 	}, parent)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	slice := model.NewSlice(fragment, oldSlice.OpenStart, oldSlice.OpenEnd)
 	return FromReplace(doc, s.From, s.To, slice)
@@ -127,18 +139,13 @@ func AddMarkStepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step
 	if err != nil {
 		return nil, err
 	}
-	var from, to int
-	switch f := obj["from"].(type) {
-	case int:
-		from = f
-	case float64:
-		from = int(f)
+	from, err := asInt(obj, "from")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for AddMarkStep.fromJSON: %w", err)
 	}
-	switch t := obj["to"].(type) {
-	case int:
-		to = t
-	case float64:
-		to = int(t)
+	to, err := asInt(obj, "to")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for AddMarkStep.fromJSON: %w", err)
 	}
 	return NewAddMarkStep(from, to, mark), nil
 }
@@ -161,13 +168,13 @@ func NewRemoveMarkStep(from, to int, mark *model.Mark) *RemoveMarkStep {
 func (s *RemoveMarkStep) Apply(doc *model.Node) StepResult {
 	oldSlice, err := doc.Slice(s.From, s.To)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	fragment, err := mapFragment(oldSlice.Content, func(node, parent *model.Node) *model.Node {
 		return node.Mark(s.Mark.RemoveFromSet(node.Marks))
 	}, nil)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	slice := model.NewSlice(fragment, oldSlice.OpenStart, oldSlice.OpenEnd)
 	return FromReplace(doc, s.From, s.To, slice)
@@ -230,18 +237,13 @@ func RemoveMarkStepFromJSON(schema *model.Schema, obj map[string]interface{}) (S
 	if err != nil {
 		return nil, err
 	}
-	var from, to int
-	switch f := obj["from"].(type) {
-	case int:
-		from = f
-	case float64:
-		from = int(f)
-	}
-	switch t := obj["to"].(type) {
-	case int:
-		to = t
-	case float64:
-		to = int(t)
+	from, err := asInt(obj, "from")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for RemoveMarkStep.fromJSON: %w", err)
+	}
+	to, err := asInt(obj, "to")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for RemoveMarkStep.fromJSON: %w", err)
 	}
 	return NewRemoveMarkStep(from, to, mark), nil
 }
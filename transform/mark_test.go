@@ -0,0 +1,99 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformAddMark(t *testing.T) {
+	foo := map[string]interface{}{"href": "foo"}
+	bar := map[string]interface{}{"href": "bar"}
+
+	// adds a mark to the inline content of several blocks
+	tr := NewTransform(doc(p("hello"), p("world")).Node)
+	assert.NoError(t, tr.AddMark(3, 10, schema.Mark("em")))
+	assert.True(t, tr.Doc.Eq(doc(p("he", em("llo")), p(em("wor"), "ld")).Node), tr.Doc.String())
+
+	// uses a single step for adjacent nodes
+	tr = NewTransform(doc(p("one", strong("two"), "three")).Node)
+	assert.NoError(t, tr.AddMark(1, 12, schema.Mark("em")))
+	assert.Len(t, tr.Steps, 1)
+	assert.True(t, tr.Doc.Eq(doc(p(em("one", strong("two"), "three"))).Node), tr.Doc.String())
+
+	// doesn't add a step for content that already has the mark
+	tr = NewTransform(doc(p(em("hello"))).Node)
+	assert.NoError(t, tr.AddMark(1, 6, schema.Mark("em")))
+	assert.False(t, tr.DocChanged())
+
+	// skips the content of nodes that don't allow the mark
+	tr = NewTransform(doc(p("ab"), builder.Pre("cd")).Node)
+	assert.NoError(t, tr.AddMark(1, 7, schema.Mark("em")))
+	assert.True(t, tr.Doc.Eq(doc(p(em("ab")), builder.Pre("cd")).Node), tr.Doc.String())
+
+	// removes the marks excluded by the new mark
+	tr = NewTransform(doc(p(a(foo, "hello"), " world")).Node)
+	assert.NoError(t, tr.AddMark(1, 12, schema.Mark("link", bar)))
+	assert.True(t, tr.Doc.Eq(doc(p(a(bar, "hello world"))).Node), tr.Doc.String())
+}
+
+func TestTransformAddMarkToNodes(t *testing.T) {
+	out := commentSchema()
+	cSchema := out["schema"].(*model.Schema)
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+	comment := out["comment"].(builder.MarkBuilder)
+
+	// marks a block covered by the range as a node, and its inline content
+	tr := NewTransform(doc(p("one"), p("two")).Node)
+	assert.NoError(t, tr.AddMark(0, 7, cSchema.Mark("comment")))
+	assert.True(t, tr.Doc.Eq(doc(comment(p(comment("one"))), p(comment("tw"), "o")).Node), tr.Doc.String())
+	if assert.Len(t, tr.Steps, 3) {
+		assert.Equal(t, NewAddMarkStep(1, 4, cSchema.Mark("comment")), tr.Steps[0])
+		assert.Equal(t, NewAddMarkStep(6, 7, cSchema.Mark("comment")), tr.Steps[1])
+		assert.Equal(t, NewAddNodeMarkStep(0, cSchema.Mark("comment")), tr.Steps[2])
+	}
+
+	// doesn't mark the blocks whose parent doesn't allow the mark
+	tr = NewTransform(doc(p("one")).Node)
+	assert.NoError(t, tr.AddMark(0, 5, cSchema.Mark("em")))
+	em := out["em"].(builder.MarkBuilder)
+	assert.True(t, tr.Doc.Eq(doc(p(em("one"))).Node), tr.Doc.String())
+}
+
+func TestTransformRemoveMark(t *testing.T) {
+	foo := map[string]interface{}{"href": "foo"}
+	bar := map[string]interface{}{"href": "bar"}
+
+	linkType, err := schema.MarkType("link")
+	assert.NoError(t, err)
+
+	// removes all the marks of a type, whatever their attributes
+	tr := NewTransform(doc(p(a(foo, "hello"), " ", a(bar, em("world")))).Node)
+	assert.NoError(t, tr.RemoveMark(1, 12, linkType))
+	assert.True(t, tr.Doc.Eq(doc(p("hello ", em("world"))).Node), tr.Doc.String())
+
+	// removes only the given mark
+	tr = NewTransform(doc(p(a(foo, "hello"), " ", a(bar, "world"))).Node)
+	assert.NoError(t, tr.RemoveMark(1, 12, schema.Mark("link", foo)))
+	assert.True(t, tr.Doc.Eq(doc(p("hello ", a(bar, "world"))).Node), tr.Doc.String())
+
+	// removes marks only in the given range
+	tr = NewTransform(doc(p(strong("hello"), em(" world"))).Node)
+	assert.NoError(t, tr.RemoveMark(3, 9, nil))
+	assert.True(t, tr.Doc.Eq(doc(p(strong("he"), "llo wo", em("rld"))).Node), tr.Doc.String())
+
+	// merges the removed ranges across adjacent nodes
+	tr = NewTransform(doc(p(em("one", strong("two")), em("three"))).Node)
+	assert.NoError(t, tr.RemoveMark(1, 12, schema.Mark("em")))
+	assert.Len(t, tr.Steps, 1)
+	assert.True(t, tr.Doc.Eq(doc(p("one", strong("two"), "three")).Node), tr.Doc.String())
+
+	// rejects an invalid mark, even on a range without inline content
+	tr = NewTransform(doc(p("hello")).Node)
+	assert.Error(t, tr.RemoveMark(1, 4, "em"))
+	assert.Error(t, tr.RemoveMark(0, 0, "em"))
+	assert.Empty(t, tr.Steps)
+}
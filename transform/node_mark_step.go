@@ -0,0 +1,198 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// nodeMarkSlice returns the slice that replaces the node at pos with a copy
+// that has the given marks, keeping its content.
+func nodeMarkSlice(doc *model.Node, pos int, marks func(node *model.Node) []*model.Mark) (*model.Slice, error) {
+	node, err := doc.NodeAtE(pos)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, errors.New("No node at mark step's position")
+	}
+	// The new node is created empty, but the slice is open at its end, so
+	// the original content of the node is joined into it by the replace.
+	updated, err := node.Type.Create(node.Attrs, nil, marks(node))
+	if err != nil {
+		return nil, err
+	}
+	leaf := 0
+	if !node.IsLeaf() {
+		leaf = 1
+	}
+	return model.NewSlice(model.NewFragment([]*model.Node{updated}), 0, leaf), nil
+}
+
+// AddNodeMarkStep adds a mark to a specific node.
+type AddNodeMarkStep struct {
+	Pos  int
+	Mark *model.Mark
+}
+
+// NewAddNodeMarkStep is the constructor for AddNodeMarkStep.
+func NewAddNodeMarkStep(pos int, mark *model.Mark) *AddNodeMarkStep {
+	return &AddNodeMarkStep{Pos: pos, Mark: mark}
+}
+
+// Apply is a method of the Step interface.
+func (s *AddNodeMarkStep) Apply(doc *model.Node) StepResult {
+	slice, err := nodeMarkSlice(doc, s.Pos, func(node *model.Node) []*model.Mark {
+		return s.Mark.AddToSet(node.Marks)
+	})
+	if err != nil {
+		return FailWithError(err)
+	}
+	return FromReplace(doc, s.Pos, s.Pos+1, slice)
+}
+
+// GetMap is a method of the Step interface.
+func (s *AddNodeMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Invert is a method of the Step interface. When the mark replaces an
+// excluded mark of the node, the inverted step adds this mark back.
+func (s *AddNodeMarkStep) Invert(doc *model.Node) Step {
+	node, err := doc.NodeAtE(s.Pos)
+	if err == nil && node != nil {
+		newSet := s.Mark.AddToSet(node.Marks)
+		if len(newSet) == len(node.Marks) {
+			for _, m := range node.Marks {
+				if !m.IsInSet(newSet) {
+					return NewAddNodeMarkStep(s.Pos, m)
+				}
+			}
+			return NewAddNodeMarkStep(s.Pos, s.Mark)
+		}
+	}
+	return NewRemoveNodeMarkStep(s.Pos, s.Mark)
+}
+
+// Map is a method of the Step interface.
+func (s *AddNodeMarkStep) Map(mapping Mappable) Step {
+	result := mapping.MapResult(s.Pos, 1)
+	if result.Deleted {
+		return nil
+	}
+	return NewAddNodeMarkStep(result.Pos, s.Mark)
+}
+
+// Merge is a method of the Step interface.
+func (s *AddNodeMarkStep) Merge(other Step) (Step, bool) {
+	return nil, false
+}
+
+// ToJSON is a method of the Step interface.
+func (s *AddNodeMarkStep) ToJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"stepType": "addNodeMark",
+		"pos":      s.Pos,
+		"mark":     s.Mark.ToJSON(),
+	}
+}
+
+// AddNodeMarkStepFromJSON builds an AddNodeMarkStep from a JSON
+// representation.
+func AddNodeMarkStepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error) {
+	raw, ok := obj["mark"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Invalid input for AddNodeMarkStep.fromJSON")
+	}
+	mark, err := model.MarkFromJSON(schema, raw)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := asInt(obj, "pos")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for AddNodeMarkStep.fromJSON: %w", err)
+	}
+	return NewAddNodeMarkStep(pos, mark), nil
+}
+
+var _ Step = &AddNodeMarkStep{}
+
+// RemoveNodeMarkStep removes a mark from a specific node.
+type RemoveNodeMarkStep struct {
+	Pos  int
+	Mark *model.Mark
+}
+
+// NewRemoveNodeMarkStep is the constructor for RemoveNodeMarkStep.
+func NewRemoveNodeMarkStep(pos int, mark *model.Mark) *RemoveNodeMarkStep {
+	return &RemoveNodeMarkStep{Pos: pos, Mark: mark}
+}
+
+// Apply is a method of the Step interface.
+func (s *RemoveNodeMarkStep) Apply(doc *model.Node) StepResult {
+	slice, err := nodeMarkSlice(doc, s.Pos, func(node *model.Node) []*model.Mark {
+		return s.Mark.RemoveFromSet(node.Marks)
+	})
+	if err != nil {
+		return FailWithError(err)
+	}
+	return FromReplace(doc, s.Pos, s.Pos+1, slice)
+}
+
+// GetMap is a method of the Step interface.
+func (s *RemoveNodeMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Invert is a method of the Step interface.
+func (s *RemoveNodeMarkStep) Invert(doc *model.Node) Step {
+	node, err := doc.NodeAtE(s.Pos)
+	if err != nil || node == nil || !s.Mark.IsInSet(node.Marks) {
+		return s
+	}
+	return NewAddNodeMarkStep(s.Pos, s.Mark)
+}
+
+// Map is a method of the Step interface.
+func (s *RemoveNodeMarkStep) Map(mapping Mappable) Step {
+	result := mapping.MapResult(s.Pos, 1)
+	if result.Deleted {
+		return nil
+	}
+	return NewRemoveNodeMarkStep(result.Pos, s.Mark)
+}
+
+// Merge is a method of the Step interface.
+func (s *RemoveNodeMarkStep) Merge(other Step) (Step, bool) {
+	return nil, false
+}
+
+// ToJSON is a method of the Step interface.
+func (s *RemoveNodeMarkStep) ToJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"stepType": "removeNodeMark",
+		"pos":      s.Pos,
+		"mark":     s.Mark.ToJSON(),
+	}
+}
+
+// RemoveNodeMarkStepFromJSON builds a RemoveNodeMarkStep from a JSON
+// representation.
+func RemoveNodeMarkStepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error) {
+	raw, ok := obj["mark"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Invalid input for RemoveNodeMarkStep.fromJSON")
+	}
+	mark, err := model.MarkFromJSON(schema, raw)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := asInt(obj, "pos")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for RemoveNodeMarkStep.fromJSON: %w", err)
+	}
+	return NewRemoveNodeMarkStep(pos, mark), nil
+}
+
+var _ Step = &RemoveNodeMarkStep{}
@@ -0,0 +1,61 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeMarkSteps(t *testing.T) {
+	out := commentSchema()
+	cSchema := out["schema"].(*model.Schema)
+	doc := out["doc"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+	comment := out["comment"].(builder.MarkBuilder)
+	mark := cSchema.Mark("comment")
+
+	apply := func(step Step, before, expected *model.Node) {
+		result := step.Apply(before)
+		if assert.NoError(t, result.Err) {
+			assert.True(t, result.Doc.Eq(expected), "%s != %s\n", result.Doc, expected)
+			inverted := step.Invert(before).Apply(result.Doc)
+			if assert.NoError(t, inverted.Err) {
+				assert.True(t, inverted.Doc.Eq(before), "%s != %s\n", inverted.Doc, before)
+			}
+		}
+	}
+
+	// adds a mark to a block node, and keeps its content
+	plain := doc(p("one"), p("two")).Node
+	marked := doc(p("one"), comment(p("two"))).Node
+	apply(NewAddNodeMarkStep(5, mark), plain, marked)
+
+	// removes a mark from a block node
+	apply(NewRemoveNodeMarkStep(5, mark), marked, plain)
+
+	// replaces an excluded mark, and restores it when inverted
+	other := cSchema.Mark("comment", map[string]interface{}{"id": 2.0})
+	apply(NewAddNodeMarkStep(5, other), marked, doc(p("one"), comment(map[string]interface{}{"id": 2.0}, p("two"))).Node)
+
+	// fails when the parent doesn't allow the mark
+	assert.Error(t, NewAddNodeMarkStep(0, cSchema.Mark("em")).Apply(plain).Err)
+
+	// fails when there is no node at the position
+	assert.Error(t, NewAddNodeMarkStep(10, mark).Apply(plain).Err)
+
+	// is dropped when its node is deleted
+	assert.Nil(t, NewAddNodeMarkStep(5, mark).Map(NewStepMap([]int{5, 5, 0})))
+	assert.Equal(t, 2, NewRemoveNodeMarkStep(5, mark).Map(NewStepMap([]int{0, 3, 0})).(*RemoveNodeMarkStep).Pos)
+
+	// round-trips through JSON
+	for _, step := range []Step{NewAddNodeMarkStep(5, mark), NewRemoveNodeMarkStep(5, mark)} {
+		fromJSON, err := StepFromJSON(cSchema, step.ToJSON())
+		require.NoError(t, err)
+		assert.Equal(t, step.ToJSON(), fromJSON.ToJSON())
+	}
+	_, err := StepFromJSON(cSchema, map[string]interface{}{"stepType": "addNodeMark", "pos": 5})
+	assert.Error(t, err)
+}
@@ -1,7 +1,7 @@
 package transform
 
 import (
-	"errors"
+	"fmt"
 
 	"github.com/cozy/prosemirror-go/model"
 )
@@ -107,22 +107,13 @@ func (s *ReplaceStep) ToJSON() map[string]interface{} {
 
 // ReplaceStepFromJSON builds an RemoveMarkStep from a JSON representation.
 func ReplaceStepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error) {
-	var from, to int
-	switch f := obj["from"].(type) {
-	case int:
-		from = f
-	case float64:
-		from = int(f)
-	default:
-		return nil, errors.New("Invalid input for ReplaceStep.fromJSON")
-	}
-	switch t := obj["to"].(type) {
-	case int:
-		to = t
-	case float64:
-		to = int(t)
-	default:
-		return nil, errors.New("Invalid input for ReplaceStep.fromJSON")
+	from, err := asInt(obj, "from")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for ReplaceStep.fromJSON: %w", err)
+	}
+	to, err := asInt(obj, "to")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for ReplaceStep.fromJSON: %w", err)
 	}
 	raw, _ := obj["slice"].(map[string]interface{})
 	slice, err := model.SliceFromJSON(schema, raw)
@@ -141,6 +132,26 @@ func TableSortStepFromJSON(schema *model.Schema, obj map[string]interface{}) (St
 
 var _ Step = &ReplaceStep{}
 
+// ReplaceStepFromDiff returns a replace step that turns the document a into
+// the document b, by replacing only the range that has changed between them
+// (see model.DiffRange). It returns nil when the documents have the same
+// content.
+func ReplaceStepFromDiff(a, b *model.Node) (Step, error) {
+	from, toA, toB, ok := model.DiffRange(a, b)
+	if !ok {
+		return nil, nil
+	}
+	slice, err := b.Slice(from, toB)
+	if err != nil {
+		return nil, err
+	}
+	step := NewReplaceStep(from, toA, slice)
+	if result := step.Apply(a); result.Failed != "" {
+		return nil, &TransformError{Message: result.Failed, Err: result.Err}
+	}
+	return step, nil
+}
+
 // ReplaceAroundStep replaces a part of the document with a slice of content,
 // but preserve a range of the replaced content by moving it into the slice.
 type ReplaceAroundStep struct {
@@ -169,7 +180,7 @@ func (s *ReplaceAroundStep) Apply(doc *model.Node) StepResult {
 
 	gap, err := doc.Slice(s.GapFrom, s.GapTo)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	if gap.OpenStart != 0 && gap.OpenEnd != 0 {
 		return Fail("Gap is not a flat range")
@@ -194,7 +205,7 @@ func (s *ReplaceAroundStep) Invert(doc *model.Node) Step {
 	if err != nil {
 		return nil
 	}
-	removed, err := slice.RemoveBetween(s.GapFrom-s.From, s.GapTo-s.To)
+	removed, err := slice.RemoveBetween(s.GapFrom-s.From, s.GapTo-s.From)
 	if err != nil {
 		return nil
 	}
@@ -241,46 +252,25 @@ func (s *ReplaceAroundStep) ToJSON() map[string]interface{} {
 
 // ReplaceAroundStepFromJSON builds an RemoveMarkStep from a JSON representation.
 func ReplaceAroundStepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error) {
-	var from, to, gapFrom, gapTo, insert int
-	switch f := obj["from"].(type) {
-	case int:
-		from = f
-	case float64:
-		from = int(f)
-	default:
-		return nil, errors.New("Invalid input for ReplaceAroundStep.fromJSON")
-	}
-	switch t := obj["to"].(type) {
-	case int:
-		to = t
-	case float64:
-		to = int(t)
-	default:
-		return nil, errors.New("Invalid input for ReplaceAroundStep.fromJSON")
-	}
-	switch f := obj["gapFrom"].(type) {
-	case int:
-		gapFrom = f
-	case float64:
-		gapFrom = int(f)
-	default:
-		return nil, errors.New("Invalid input for ReplaceAroundStep.fromJSON")
-	}
-	switch t := obj["gapTo"].(type) {
-	case int:
-		gapTo = t
-	case float64:
-		gapTo = int(t)
-	default:
-		return nil, errors.New("Invalid input for ReplaceAroundStep.fromJSON")
-	}
-	switch n := obj["insert"].(type) {
-	case int:
-		insert = n
-	case float64:
-		insert = int(n)
-	default:
-		return nil, errors.New("Invalid input for ReplaceAroundStep.fromJSON")
+	from, err := asInt(obj, "from")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for ReplaceAroundStep.fromJSON: %w", err)
+	}
+	to, err := asInt(obj, "to")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for ReplaceAroundStep.fromJSON: %w", err)
+	}
+	gapFrom, err := asInt(obj, "gapFrom")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for ReplaceAroundStep.fromJSON: %w", err)
+	}
+	gapTo, err := asInt(obj, "gapTo")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for ReplaceAroundStep.fromJSON: %w", err)
+	}
+	insert, err := asInt(obj, "insert")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for ReplaceAroundStep.fromJSON: %w", err)
 	}
 	raw, _ := obj["slice"].(map[string]interface{})
 	slice, err := model.SliceFromJSON(schema, raw)
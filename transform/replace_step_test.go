@@ -5,6 +5,7 @@ import (
 
 	"github.com/cozy/prosemirror-go/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestReplaceAround(t *testing.T) {
@@ -51,3 +52,37 @@ func TestReplaceTwice(t *testing.T) {
 	// An emoji in JS counts as 2 UTF-16 code units
 	yes(2, 2, "👥", "N👥uméro", 4, 4, "🔎", "N👥🔎uméro")
 }
+
+func TestReplaceStepFromDiff(t *testing.T) {
+	check := func(a, b *model.Node, size int) {
+		step, err := ReplaceStepFromDiff(a, b)
+		require.NoError(t, err)
+		require.NotNil(t, step)
+		rs := step.(*ReplaceStep)
+		assert.Equal(t, size, rs.Slice.Size())
+		result := step.Apply(a)
+		if assert.Empty(t, result.Failed) {
+			assert.True(t, result.Doc.Eq(b), "%s != %s", result.Doc, b)
+		}
+	}
+
+	// changes some text in the middle paragraph
+	check(doc(p("one"), p("two"), p("three")).Node, doc(p("one"), p("tXo"), p("three")).Node, 1)
+
+	// inserts a paragraph
+	check(doc(p("one"), p("three")).Node, doc(p("one"), p("two"), p("three")).Node, 5)
+
+	// deletes some content across paragraphs
+	check(doc(p("one"), p("two"), p("three")).Node, doc(p("one"), p("three")).Node, 0)
+
+	// changes the markup of a node
+	check(doc(p("one"), p("two")).Node, doc(p("one"), h1("two")).Node, 5)
+
+	// changes the marks of some text
+	check(doc(p("one two")).Node, doc(p("one ", em("two"))).Node, 3)
+
+	// returns nil for identical documents
+	step, err := ReplaceStepFromDiff(doc(p("one")).Node, doc(p("one")).Node)
+	assert.NoError(t, err)
+	assert.Nil(t, step)
+}
@@ -2,6 +2,7 @@ package transform
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/cozy/prosemirror-go/model"
 )
@@ -23,7 +24,10 @@ func NewSetAttrsStep(pos int, attrs map[string]interface{}) *SetAttrsStep {
 
 // Apply is a method of the Step interface.
 func (s *SetAttrsStep) Apply(doc *model.Node) StepResult {
-	target := doc.NodeAt(s.Pos)
+	target, err := doc.NodeAtE(s.Pos)
+	if err != nil {
+		return FailWithError(err)
+	}
 	if target == nil {
 		return Fail("No node at given position")
 	}
@@ -36,9 +40,12 @@ func (s *SetAttrsStep) Apply(doc *model.Node) StepResult {
 		attrs[k] = v
 	}
 
+	// The new node is created empty, but the slice is open at its end, so
+	// the original content of the node (after s.Pos+1) is joined into it by
+	// the replace.
 	newNode, err := target.Type.Create(attrs, model.EmptyFragment, target.Marks)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	leaf := 0
 	if !target.IsLeaf() {
@@ -46,7 +53,7 @@ func (s *SetAttrsStep) Apply(doc *model.Node) StepResult {
 	}
 	fragment, err := model.FragmentFrom(newNode)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	slice := model.NewSlice(fragment, 0, leaf)
 	return FromReplace(doc, s.Pos, s.Pos+1, slice)
@@ -60,8 +67,8 @@ func (s *SetAttrsStep) GetMap() *StepMap {
 // Invert is a method of the Step interface.
 func (s *SetAttrsStep) Invert(doc *model.Node) Step {
 	attrs := map[string]interface{}{}
-	target := doc.NodeAt(s.Pos)
-	if target != nil {
+	target, err := doc.NodeAtE(s.Pos)
+	if err == nil && target != nil {
 		attrs = target.Attrs
 	}
 	return NewSetAttrsStep(s.Pos, attrs)
@@ -96,12 +103,9 @@ func SetAttrsStepFromJSON(schema *model.Schema, obj map[string]interface{}) (Ste
 	if !ok {
 		return nil, errors.New("Invalid input for SetAttrsStep.fromJSON")
 	}
-	var pos int
-	switch p := obj["pos"].(type) {
-	case int:
-		pos = p
-	case float64:
-		pos = int(p)
+	pos, err := asInt(obj, "pos")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input for SetAttrsStep.fromJSON: %w", err)
 	}
 	return NewSetAttrsStep(pos, attrs), nil
 }
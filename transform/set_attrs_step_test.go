@@ -0,0 +1,56 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetAttrsStep(t *testing.T) {
+	apply := func(d builder.NodeWithTag, pos int, attrs map[string]interface{}, expect builder.NodeWithTag) {
+		step := NewSetAttrsStep(pos, attrs)
+		result := step.Apply(d.Node)
+		if assert.Empty(t, result.Failed) {
+			assert.True(t, result.Doc.Eq(expect.Node), "%s != %s\n", result.Doc.String(), expect.String())
+			inverted := step.Invert(d.Node).Apply(result.Doc)
+			if assert.Empty(t, inverted.Failed) {
+				assert.True(t, inverted.Doc.Eq(d.Node), "%s != %s\n", inverted.Doc.String(), d.String())
+			}
+		}
+	}
+
+	// changes the attributes of a textblock and keeps its content
+	apply(doc(p("a"), h1("b", em("c"))), 3, map[string]interface{}{"level": 2},
+		doc(p("a"), builder.H2("b", em("c"))))
+
+	// keeps the children of a container node
+	apply(doc(ol(li(p("a")), li(p("b")))), 0, map[string]interface{}{"order": 3.0},
+		doc(ol(map[string]interface{}{"order": 3.0}, li(p("a")), li(p("b")))))
+
+	// changes the attributes of a leaf node
+	apply(doc(p("a", img, "b")), 2, map[string]interface{}{"src": "other.png"},
+		doc(p("a", img(map[string]interface{}{"src": "other.png"}), "b")))
+
+	// fails when there is no node at the position
+	result := NewSetAttrsStep(12, map[string]interface{}{}).Apply(doc(p("a")).Node)
+	assert.NotEmpty(t, result.Failed)
+}
+
+func TestSetAttrsStepKeepsBlockquoteContent(t *testing.T) {
+	out := schemaWithAttrs("blockquote", map[string]*model.AttributeSpec{"cite": {Default: ""}})
+	doc := out["doc"].(builder.NodeBuilder)
+	bq := out["blockquote"].(builder.NodeBuilder)
+	p := out["p"].(builder.NodeBuilder)
+
+	before := doc(p("x"), bq(p("one"), p("two")))
+	result := NewSetAttrsStep(3, map[string]interface{}{"cite": "me"}).Apply(before.Node)
+	require.Empty(t, result.Failed)
+	expected := doc(p("x"), bq(map[string]interface{}{"cite": "me"}, p("one"), p("two")))
+	assert.True(t, result.Doc.Eq(expected.Node), "%s != %s\n", result.Doc.String(), expected.String())
+	quote, err := result.Doc.Child(1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, quote.ChildCount())
+}
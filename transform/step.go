@@ -51,9 +51,18 @@ type Step interface {
 
 type stepBuilder func(*model.Schema, map[string]interface{}) (Step, error)
 
+// StepMigration can be given to StepFromJSON to adjust the JSON representation
+// of a step before it is deserialized, for example when it has been serialized
+// with an older version of the schema. It receives the step type, and returns
+// the JSON to use (the given obj can be modified and returned).
+type StepMigration func(stepType string, obj map[string]interface{}) (map[string]interface{}, error)
+
 var stepsByID = map[string]stepBuilder{
 	"addMark":                         AddMarkStepFromJSON,
+	"addNodeMark":                     AddNodeMarkStepFromJSON,
+	"docAttr":                         DocAttrStepFromJSON,
 	"removeMark":                      RemoveMarkStepFromJSON,
+	"removeNodeMark":                  RemoveNodeMarkStepFromJSON,
 	"replace":                         ReplaceStepFromJSON,
 	"replaceAround":                   ReplaceAroundStepFromJSON,
 	"setAttrs":                        SetAttrsStepFromJSON,
@@ -61,8 +70,9 @@ var stepsByID = map[string]stepBuilder{
 }
 
 // StepFromJSON deserializes a step from its JSON representation. Will call
-// through to the step class' own implementation of this method.
-func StepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error) {
+// through to the step class' own implementation of this method. An optional
+// migration can be given to adjust the JSON before that.
+func StepFromJSON(schema *model.Schema, obj map[string]interface{}, migrate ...StepMigration) (Step, error) {
 	if len(obj) == 0 {
 		return nil, errors.New("Invalid input from Step.fromJSON")
 	}
@@ -70,6 +80,15 @@ func StepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error
 	if !ok {
 		return nil, errors.New("Invalid input from Step.fromJSON")
 	}
+	if len(migrate) > 0 && migrate[0] != nil {
+		var err error
+		if obj, err = migrate[0](stepType, obj); err != nil {
+			return nil, err
+		}
+		if stepType, ok = obj["stepType"].(string); !ok {
+			return nil, errors.New("Invalid input from Step.fromJSON")
+		}
+	}
 	builder, ok := stepsByID[stepType]
 	if !ok {
 		return nil, fmt.Errorf("No step %s defined", stepType)
@@ -77,6 +96,20 @@ func StepFromJSON(schema *model.Schema, obj map[string]interface{}) (Step, error
 	return builder(schema, obj)
 }
 
+// asInt returns the integer value of obj[key], which can be an int or a
+// float64 (as decoded by encoding/json) with no fractional part.
+func asInt(obj map[string]interface{}, key string) (int, error) {
+	switch v := obj[key].(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid value for %s: %v", key, obj[key])
+}
+
 // StepResult is the result of applying a step. Contains either a new document
 // or a failure value.
 type StepResult struct {
@@ -84,6 +117,9 @@ type StepResult struct {
 	Doc *model.Node
 	// :: ?string Text providing information about a failed step.
 	Failed string
+	// :: ?error The error for a failed step, which can be inspected with
+	// errors.Is and errors.As (like a *model.ReplaceError).
+	Err error
 }
 
 // OK creates a successful step result.
@@ -93,7 +129,12 @@ func OK(doc *model.Node) StepResult {
 
 // Fail creates a failed step result.
 func Fail(message string) StepResult {
-	return StepResult{Failed: message}
+	return StepResult{Failed: message, Err: errors.New(message)}
+}
+
+// FailWithError creates a failed step result for the given error.
+func FailWithError(err error) StepResult {
+	return StepResult{Failed: err.Error(), Err: err}
 }
 
 // FromReplace calls Node.replace with the given arguments. Create a successful
@@ -101,7 +142,7 @@ func Fail(message string) StepResult {
 func FromReplace(doc *model.Node, from, to int, slice *model.Slice) StepResult {
 	replaced, err := doc.Replace(from, to, slice)
 	if err != nil {
-		return Fail(err.Error())
+		return FailWithError(err)
 	}
 	return OK(replaced)
 }
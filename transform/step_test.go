@@ -1,9 +1,12 @@
 package transform
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -105,3 +108,217 @@ func TestStepMerge(t *testing.T) {
 	// doesn't merge removing separate styles
 	no(1, 2, "-em", 3, 4, "-em")
 }
+
+func TestStepMapOverBisectingDeletion(t *testing.T) {
+	mapped := func(testDoc *model.Node, step, deletion Step, expected *model.Node) {
+		deleted := deletion.Apply(testDoc)
+		if !assert.Empty(t, deleted.Failed) {
+			return
+		}
+		m := step.Map(deletion.GetMap())
+		if !assert.NotNil(t, m) {
+			return
+		}
+		result := m.Apply(deleted.Doc)
+		if assert.Empty(t, result.Failed) {
+			assert.True(t, result.Doc.Eq(expected), "%s != %s\n", result.Doc, expected)
+		}
+	}
+
+	// keeps both halves of an added mark
+	mapped(doc(p("hello world")).Node,
+		mkStep(1, 12, "+em"), mkStep(4, 8, ""),
+		doc(p(em("helorld"))).Node)
+
+	// keeps both halves when a whole block is deleted in the middle
+	mapped(doc(p("foo"), p("bar"), p("baz")).Node,
+		mkStep(2, 13, "+em"), mkStep(5, 10, ""),
+		doc(p("f", em("oo")), p(em("ba"), "z")).Node)
+
+	// keeps both halves of a removed mark
+	mapped(doc(p(em("hello world"))).Node,
+		mkStep(1, 12, "-em"), mkStep(4, 8, ""),
+		doc(p("helorld")).Node)
+}
+
+func TestStepResultErr(t *testing.T) {
+	testDoc := doc(p("hello"), p("world")).Node
+
+	// gives the replace error of an invalid step
+	step := NewReplaceStep(0, 3, model.EmptySlice)
+	result := step.Apply(testDoc)
+	var replaceErr *model.ReplaceError
+	if assert.True(t, errors.As(result.Err, &replaceErr)) {
+		assert.Equal(t, result.Failed, result.Err.Error())
+	}
+
+	// keeps the error in the transform error
+	err := NewTransform(testDoc).Step(step)
+	var transformErr *TransformError
+	assert.True(t, errors.As(err, &transformErr))
+	assert.True(t, errors.As(err, &replaceErr))
+
+	// gives an error with the message of other failures
+	structure := NewReplaceStep(1, 8, model.EmptySlice, true)
+	result = structure.Apply(testDoc)
+	if assert.Error(t, result.Err) {
+		assert.Equal(t, "Structure replace would overwrite content", result.Err.Error())
+		assert.Equal(t, result.Failed, result.Err.Error())
+	}
+
+	// has no error when the step succeeds
+	assert.NoError(t, NewReplaceStep(1, 3, model.EmptySlice).Apply(testDoc).Err)
+}
+
+func TestStepFromJSONNumbers(t *testing.T) {
+	em := schema.Mark("em")
+	slice, err := doc(p("a")).Node.Slice(1, 2)
+	assert.NoError(t, err)
+	steps := []Step{
+		NewAddMarkStep(1, 3, em),
+		NewRemoveMarkStep(1, 3, em),
+		NewReplaceStep(1, 2, slice),
+		NewReplaceAroundStep(1, 6, 2, 5, slice, 1, true),
+		NewSetAttrsStep(4, map[string]interface{}{"level": "2"}),
+	}
+
+	for _, step := range steps {
+		obj := step.ToJSON()
+		stepType := obj["stepType"]
+
+		// accepts int values
+		fromJSON, err := StepFromJSON(schema, obj)
+		if assert.NoError(t, err, stepType) {
+			assert.Equal(t, step, fromJSON, stepType)
+		}
+
+		// accepts float64 values, as decoded by encoding/json
+		raw, err := json.Marshal(obj)
+		assert.NoError(t, err)
+		var decoded map[string]interface{}
+		assert.NoError(t, json.Unmarshal(raw, &decoded))
+		fromJSON, err = StepFromJSON(schema, decoded)
+		if assert.NoError(t, err, stepType) {
+			assert.Equal(t, step.GetMap(), fromJSON.GetMap(), stepType)
+			again, err := json.Marshal(fromJSON.ToJSON())
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(raw), string(again), stepType)
+		}
+
+		// rejects fractional, missing and non-numeric values
+		for key, value := range obj {
+			if _, ok := value.(int); !ok {
+				continue
+			}
+			for _, invalid := range []interface{}{1.5, "1", nil} {
+				broken := map[string]interface{}{}
+				for k, v := range obj {
+					broken[k] = v
+				}
+				broken[key] = invalid
+				_, err := StepFromJSON(schema, broken)
+				if assert.Error(t, err, "%s %s", stepType, key) {
+					assert.Contains(t, err.Error(), "Invalid value for "+key)
+				}
+			}
+		}
+	}
+}
+
+func TestStepFromJSONMigration(t *testing.T) {
+	// renames the pos property of an older version of the setAttrs step
+	migrate := func(stepType string, obj map[string]interface{}) (map[string]interface{}, error) {
+		if stepType == "setAttrs" {
+			if at, ok := obj["at"]; ok {
+				obj["pos"] = at
+				delete(obj, "at")
+			}
+		}
+		return obj, nil
+	}
+	old := map[string]interface{}{
+		"stepType": "setAttrs",
+		"at":       float64(4),
+		"attrs":    map[string]interface{}{"level": "2"},
+	}
+	_, err := StepFromJSON(schema, old)
+	assert.Error(t, err)
+	step, err := StepFromJSON(schema, old, migrate)
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewSetAttrsStep(4, map[string]interface{}{"level": "2"}), step)
+	}
+
+	// can change the step type
+	step, err = StepFromJSON(schema, map[string]interface{}{"stepType": "noop"},
+		func(stepType string, obj map[string]interface{}) (map[string]interface{}, error) {
+			return map[string]interface{}{"stepType": "replace", "from": 0, "to": 0}, nil
+		})
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewReplaceStep(0, 0, model.EmptySlice), step)
+	}
+
+	// reports the errors of the migration
+	_, err = StepFromJSON(schema, old,
+		func(stepType string, obj map[string]interface{}) (map[string]interface{}, error) {
+			return nil, errors.New("unsupported version")
+		})
+	assert.EqualError(t, err, "unsupported version")
+}
+
+func TestMapFragmentReusesNodes(t *testing.T) {
+	emType, _ := schema.MarkType("em")
+	addEm := func(node, parent *model.Node) *model.Node {
+		return node.Mark(emType.Create(nil).AddToSet(node.Marks))
+	}
+
+	// nothing changes, the fragment is returned as is
+	unchanged := doc(p(em("foo")), p(em("bar"))).Node.Content
+	mapped, err := mapFragment(unchanged, addEm, nil)
+	assert.NoError(t, err)
+	assert.Same(t, unchanged, mapped)
+
+	// only the changed paragraph is copied
+	content := doc(p(em("foo")), p("bar")).Node.Content
+	mapped, err = mapFragment(content, addEm, nil)
+	assert.NoError(t, err)
+	assert.True(t, mapped.Eq(doc(p(em("foo")), p(em("bar"))).Node.Content), mapped.String())
+	assert.Same(t, content.Content[0], mapped.Content[0])
+	assert.NotSame(t, content.Content[1], mapped.Content[1])
+}
+
+func BenchmarkAddMarkStepSmallSpan(b *testing.B) {
+	var inline []interface{}
+	for i := 0; i < 500; i++ {
+		inline = append(inline, "some plain text ", strong("and some strong text "))
+	}
+	node := doc(p(inline...)).Node
+	emType, _ := schema.MarkType("em")
+	step := NewAddMarkStep(1000, 1010, emType.Create(nil))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := step.Apply(node)
+		if result.Failed != "" {
+			b.Fatal(result.Failed)
+		}
+	}
+}
+
+func TestReplaceAroundStepInvert(t *testing.T) {
+	blockquote := builder.Blockquote
+	testDoc := doc(blockquote(p("ab")), p("cd")).Node
+
+	// restores the wrapper removed around a gap that ends before the step
+	step := NewReplaceAroundStep(0, 6, 1, 5, model.EmptySlice, 0, true)
+	result := step.Apply(testDoc)
+	if assert.NoError(t, result.Err) {
+		assert.True(t, result.Doc.Eq(doc(p("ab"), p("cd")).Node), "%s", result.Doc)
+		inverted := step.Invert(testDoc)
+		if assert.NotNil(t, inverted) {
+			back := inverted.Apply(result.Doc)
+			if assert.NoError(t, back.Err) {
+				assert.True(t, back.Doc.Eq(testDoc), "%s != %s", back.Doc, testDoc)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package transform
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// ClearIncompatible removes the marks and nodes of the node at pos that are
+// not allowed by the given parent type, and adds the nodes needed to make its
+// content valid. It is used before changing the type of a node. match is the
+// content match to start from, and defaults to the start of parentType's
+// content expression.
+func (tr *Transform) ClearIncompatible(pos int, parentType *model.NodeType, match ...*model.ContentMatch) error {
+	m := parentType.ContentMatch
+	if len(match) > 0 && match[0] != nil {
+		m = match[0]
+	}
+	return clearIncompatible(tr, pos, parentType, m)
+}
+
+func clearIncompatible(tr *Transform, pos int, parentType *model.NodeType, match *model.ContentMatch) error {
+	node, err := tr.Doc.NodeAtE(pos)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return &TransformError{Message: "No node at given position"}
+	}
+	var delSteps []Step
+	cur := pos + 1
+	for i := 0; i < node.ChildCount(); i++ {
+		child, err := node.Child(i)
+		if err != nil {
+			return err
+		}
+		end := cur + child.NodeSize()
+		allowed := match.MatchType(child.Type)
+		if allowed == nil {
+			delSteps = append(delSteps, NewReplaceStep(cur, end, model.EmptySlice))
+		} else {
+			match = allowed
+			for _, mark := range child.Marks {
+				if !parentType.AllowsMarkType(mark.Type) {
+					if err := tr.Step(NewRemoveMarkStep(cur, end, mark)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		cur = end
+	}
+	if !match.ValidEnd {
+		fill := match.FillBefore(model.EmptyFragment, true)
+		if fill == nil {
+			return &TransformError{Message: "Can't fill the content of " + parentType.Name}
+		}
+		if err := tr.Replace(cur, cur, model.NewSlice(fill, 0, 0)); err != nil {
+			return err
+		}
+	}
+	for i := len(delSteps) - 1; i >= 0; i-- {
+		if err := tr.Step(delSteps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetNodeMarkup changes the type, attributes, and/or marks of the node at pos.
+// When typ is nil, the existing node type is preserved, and when marks are not
+// given, the existing marks are kept. The content of the node is moved with a
+// ReplaceAroundStep, so the positions inside it are mapped through the
+// transform's mapping.
+func (tr *Transform) SetNodeMarkup(pos int, typ *model.NodeType, attrs map[string]interface{}, marks ...[]*model.Mark) error {
+	node, err := tr.Doc.NodeAtE(pos)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return &TransformError{Message: "No node at given position"}
+	}
+	if typ == nil {
+		typ = node.Type
+	}
+	nodeMarks := node.Marks
+	if len(marks) > 0 && marks[0] != nil {
+		nodeMarks = marks[0]
+	}
+	newNode, err := typ.Create(attrs, nil, nodeMarks)
+	if err != nil {
+		return err
+	}
+	fragment, err := model.FragmentFrom(newNode)
+	if err != nil {
+		return err
+	}
+	end := pos + node.NodeSize()
+	if node.IsLeaf() {
+		return tr.Replace(pos, end, model.NewSlice(fragment, 0, 0))
+	}
+	if !typ.ValidContent(node.Content) {
+		return &TransformError{Message: "Invalid content for node type " + typ.Name}
+	}
+	return tr.Step(NewReplaceAroundStep(pos, end, pos+1, end-1, model.NewSlice(fragment, 0, 0), 1, true))
+}
+
+// CanJoin tests whether the blocks before and after a given position can be
+// joined.
+func CanJoin(doc *model.Node, pos int) bool {
+	rpos, err := doc.Resolve(pos)
+	if err != nil {
+		return false
+	}
+	index := rpos.Index()
+	before, err := rpos.NodeBefore()
+	if err != nil {
+		return false
+	}
+	after, err := rpos.NodeAfter()
+	if err != nil {
+		return false
+	}
+	return joinable(before, after) && rpos.Parent().CanReplace(index, index+1)
+}
+
+func joinable(a, b *model.Node) bool {
+	return a != nil && b != nil && !a.IsLeaf() && a.CanAppend(b)
+}
+
+// Join the blocks around the given position. If depth is 2, their last and
+// first siblings are also joined, and so on.
+func (tr *Transform) Join(pos int, depth ...int) error {
+	d := 1
+	if len(depth) > 0 {
+		d = depth[0]
+	}
+	return tr.Step(NewReplaceStep(pos-d, pos+d, model.EmptySlice, true))
+}
+
+// Normalize joins the adjacent blocks that can be joined (see CanJoin) and for
+// which shouldJoin returns true, like two adjacent lists of the same type. The
+// blocks that become adjacent when their parents are joined are also
+// considered. shouldJoin is required, as joining all the blocks that can be
+// joined would merge every paragraph of a document.
+func (tr *Transform) Normalize(shouldJoin func(before, after *model.Node) bool) error {
+	if shouldJoin == nil {
+		return errors.New("Normalize needs a function to choose the blocks to join")
+	}
+	for {
+		var positions []int
+		check := func(parent *model.Node, start int) {
+			var before *model.Node
+			parent.ForEach(func(child *model.Node, offset, _ int) {
+				pos := start + offset
+				if before != nil && before.IsBlock() && child.IsBlock() &&
+					shouldJoin(before, child) && CanJoin(tr.Doc, pos) {
+					positions = append(positions, pos)
+				}
+				before = child
+			})
+		}
+		check(tr.Doc, 0)
+		tr.Doc.NodesBetween(0, tr.Doc.Content.Size, func(node *model.Node, pos int, _ *model.Node, _ int) bool {
+			if node.IsTextblock() {
+				return false
+			}
+			check(node, pos+1)
+			return true
+		})
+		if len(positions) == 0 {
+			return nil
+		}
+		sort.Ints(positions)
+		// Join from the end of the document, so that the positions that
+		// have not been joined yet are not changed.
+		for i := len(positions) - 1; i >= 0; i-- {
+			if err := tr.Join(positions[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
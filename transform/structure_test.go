@@ -0,0 +1,198 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClearIncompatible(t *testing.T) {
+	codeBlock, err := schema.NodeType("code_block")
+	assert.NoError(t, err)
+	heading, err := schema.NodeType("heading")
+	assert.NoError(t, err)
+
+	clear := func(testDoc *model.Node, pos int, parentType *model.NodeType, expected *model.Node) {
+		tr := NewTransform(testDoc)
+		if assert.NoError(t, tr.ClearIncompatible(pos, parentType)) {
+			assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc, expected)
+		}
+	}
+
+	// removes an image for a code block
+	clear(doc(p("foo", img, "bar")).Node, 0, codeBlock,
+		doc(p("foobar")).Node)
+
+	// removes disallowed marks
+	clear(doc(p("foo", em("bar")), p("baz")).Node, 0, codeBlock,
+		doc(p("foobar"), p("baz")).Node)
+
+	// keeps content allowed by the parent type
+	clear(doc(p("foo", img, em("bar"))).Node, 0, heading,
+		doc(p("foo", img, em("bar"))).Node)
+
+	// fails when there is no node at the position
+	tr := NewTransform(doc(p("foo")).Node)
+	assert.Error(t, tr.ClearIncompatible(5, codeBlock))
+	assert.False(t, tr.DocChanged())
+}
+
+func TestSetNodeMarkup(t *testing.T) {
+	heading, err := schema.NodeType("heading")
+	assert.NoError(t, err)
+	codeBlock, err := schema.NodeType("code_block")
+	assert.NoError(t, err)
+	level2 := map[string]interface{}{"level": 2}
+
+	// changes the type of a paragraph, and keeps the positions inside it
+	tr := NewTransform(doc(p("foo"), p("bar")).Node)
+	assert.NoError(t, tr.SetNodeMarkup(5, heading, level2))
+	assert.True(t, tr.Doc.Eq(doc(p("foo"), builder.H2("bar")).Node), tr.Doc.String())
+	for pos := 6; pos <= 9; pos++ {
+		assert.Equal(t, pos, tr.Mapping.Map(pos))
+	}
+	from, to := tr.MapSelection(7, 8)
+	assert.Equal(t, 7, from)
+	assert.Equal(t, 8, to)
+	rpos, err := tr.Doc.Resolve(tr.Mapping.Map(7))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "heading", rpos.Parent().Type.Name)
+		assert.Equal(t, 1, rpos.ParentOffset)
+	}
+
+	// changes only the attributes when no type is given
+	tr = NewTransform(doc(h1("foo")).Node)
+	assert.NoError(t, tr.SetNodeMarkup(0, nil, level2))
+	assert.True(t, tr.Doc.Eq(doc(builder.H2("foo")).Node), tr.Doc.String())
+
+	// can be inverted
+	inverted := NewTransform(tr.Doc)
+	for _, step := range tr.Invert() {
+		assert.NoError(t, inverted.Step(step))
+	}
+	assert.True(t, inverted.Doc.Eq(doc(h1("foo")).Node), inverted.Doc.String())
+
+	// replaces a leaf node
+	tr = NewTransform(doc(p("a", img, "b")).Node)
+	imgType, err := schema.NodeType("image")
+	assert.NoError(t, err)
+	assert.NoError(t, tr.SetNodeMarkup(2, imgType, map[string]interface{}{"src": "other.png"}))
+	changed := tr.Doc.NodeAt(2)
+	if assert.NotNil(t, changed) {
+		assert.Equal(t, "other.png", changed.Attrs["src"])
+	}
+
+	// fails for content that the new type doesn't allow
+	tr = NewTransform(doc(p("a", img)).Node)
+	assert.Error(t, tr.SetNodeMarkup(0, codeBlock, nil))
+	assert.False(t, tr.DocChanged())
+
+	// fails when there is no node at the position
+	assert.Error(t, tr.SetNodeMarkup(4, heading, nil))
+}
+
+func TestCanJoin(t *testing.T) {
+	d := doc(p("foo"), p("bar"), builder.Hr(), p("baz"))
+
+	// accepts joining two paragraphs
+	assert.True(t, CanJoin(d.Node, 5))
+
+	// rejects joining with a leaf node
+	assert.False(t, CanJoin(d.Node, 10))
+	assert.False(t, CanJoin(d.Node, 11))
+
+	// rejects positions inside a textblock
+	assert.False(t, CanJoin(d.Node, 2))
+}
+
+func TestTransformNormalize(t *testing.T) {
+	ul := builder.Ul
+	blockquote := builder.Blockquote
+	normalize := func(testDoc, expected *model.Node, shouldJoin func(before, after *model.Node) bool) {
+		tr := NewTransform(testDoc)
+		if assert.NoError(t, tr.Normalize(shouldJoin)) {
+			assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc, expected)
+		}
+	}
+	isList := func(before, after *model.Node) bool {
+		return before.Type.Name == "ordered_list" && before.SameMarkup(after)
+	}
+	sameMarkup := func(before, after *model.Node) bool {
+		return before.SameMarkup(after)
+	}
+
+	// joins two paragraphs
+	normalize(doc(p("foo"), p("bar")).Node, doc(p("foobar")).Node, sameMarkup)
+
+	// doesn't join paragraphs with different attributes
+	normalize(doc(h1("foo"), builder.H2("bar")).Node, doc(h1("foo"), builder.H2("bar")).Node, sameMarkup)
+
+	// leaves the paragraphs alone when only lists are joined
+	normalize(doc(p("a"), p("b")).Node, doc(p("a"), p("b")).Node, isList)
+
+	// joins only the blocks accepted by the callback
+	normalize(doc(ol(li(p("a"))), ol(li(p("b"))), p("c"), p("d")).Node,
+		doc(ol(li(p("a")), li(p("b"))), p("c"), p("d")).Node, isList)
+
+	// joins several blocks in a row
+	normalize(doc(ol(li(p("a"))), ol(li(p("b"))), ol(li(p("c")))).Node,
+		doc(ol(li(p("a")), li(p("b")), li(p("c")))).Node, isList)
+
+	// joins nested blocks
+	normalize(doc(blockquote(ol(li(p("a")))), blockquote(ol(li(p("b"))))).Node,
+		doc(blockquote(ol(li(p("a")), li(p("b"))))).Node,
+		func(before, after *model.Node) bool {
+			return before.Type.Name != "list_item" && before.SameMarkup(after)
+		})
+
+	// doesn't join blocks of different types
+	normalize(doc(ol(li(p("a"))), ul(li(p("b")))).Node, doc(ol(li(p("a"))), ul(li(p("b")))).Node, isList)
+
+	// doesn't change a normalized document
+	tr := NewTransform(doc(p("foo"), builder.Hr(), p("bar")).Node)
+	assert.NoError(t, tr.Normalize(sameMarkup))
+	assert.False(t, tr.DocChanged())
+
+	// requires a function to choose the blocks to join
+	tr = NewTransform(doc(p("a"), p("b")).Node)
+	assert.Error(t, tr.Normalize(nil))
+	assert.False(t, tr.DocChanged())
+}
+
+func TestClearIncompatibleKeepsAllowedMarks(t *testing.T) {
+	highlightOnly := "highlight"
+	s, err := model.NewSchema(&model.SchemaSpec{
+		Nodes: []*model.NodeSpec{
+			{Key: "doc", Content: "block+"},
+			{Key: "paragraph", Content: "text*", Group: "block"},
+			{Key: "code_block", Content: "text*", Group: "block", Marks: &highlightOnly},
+			{Key: "text"},
+		},
+		Marks: []*model.MarkSpec{{Key: "em"}, {Key: "highlight"}},
+	})
+	assert.NoError(t, err)
+	codeBlock, err := s.NodeType("code_block")
+	assert.NoError(t, err)
+	highlight, emphasis := s.Mark("highlight"), s.Mark("em")
+
+	node := func(name string, content ...*model.Node) *model.Node {
+		n, err := s.Node(name, nil, content)
+		assert.NoError(t, err)
+		return n
+	}
+
+	tr := NewTransform(node("doc", node("paragraph",
+		s.Text("a"),
+		s.Text("b", []*model.Mark{emphasis, highlight}),
+		s.Text("c", []*model.Mark{emphasis}),
+	)))
+	assert.NoError(t, tr.ClearIncompatible(0, codeBlock))
+	expected := node("doc", node("paragraph",
+		s.Text("a"),
+		s.Text("b", []*model.Mark{highlight}),
+		s.Text("c"),
+	))
+	assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc, expected)
+}
@@ -0,0 +1,215 @@
+package transform
+
+import (
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// TransformError is the error returned when a step can't be applied to the
+// document of a transform.
+type TransformError struct {
+	Message string
+	// The error that made the step fail, if any.
+	Err error
+}
+
+// Error returns the error message.
+func (e *TransformError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error that made the step fail.
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// Transform is an abstraction for building up and tracking an array of steps
+// representing a document transformation.
+//
+// Most transforming methods return an error when they fail to apply. Use
+// MaybeStep if you want to check the result of a step yourself.
+type Transform struct {
+	// The current document (the result of applying the steps in the
+	// transform).
+	Doc *model.Node
+	// The steps in this transform.
+	Steps []Step
+	// The documents before each of the steps.
+	Docs []*model.Node
+	// A mapping with the maps for each of the steps in this transform.
+	Mapping *Mapping
+}
+
+// NewTransform creates a transform that starts with the given document.
+func NewTransform(doc *model.Node) *Transform {
+	return &Transform{
+		Doc:     doc,
+		Mapping: NewMapping(nil),
+	}
+}
+
+// Before returns the starting document.
+func (tr *Transform) Before() *model.Node {
+	if len(tr.Docs) > 0 {
+		return tr.Docs[0]
+	}
+	return tr.Doc
+}
+
+// Step applies a new step in this transform, saving the result. Returns an
+// error when the step fails.
+func (tr *Transform) Step(step Step) error {
+	result := tr.MaybeStep(step)
+	if result.Failed != "" {
+		return &TransformError{Message: result.Failed, Err: result.Err}
+	}
+	return nil
+}
+
+// MaybeStep tries to apply a step in this transformation, ignoring it if it
+// fails. Returns the step result.
+func (tr *Transform) MaybeStep(step Step) StepResult {
+	result := step.Apply(tr.Doc)
+	if result.Failed == "" {
+		tr.addStep(step, result.Doc)
+	}
+	return result
+}
+
+// DocChanged returns true when the document has been changed (when there are
+// any steps).
+func (tr *Transform) DocChanged() bool {
+	return len(tr.Steps) > 0
+}
+
+// MapSelection maps a selection from the starting document through all the
+// steps of this transform. from is associated to the right and to to the
+// left, so that content inserted at the edges of the selection is not
+// included in it. When the selection has been deleted, or was empty, the
+// result is collapsed.
+func (tr *Transform) MapSelection(from, to int) (int, int) {
+	newFrom := tr.Mapping.Map(from, 1)
+	newTo := tr.Mapping.Map(to, -1)
+	if newTo < newFrom {
+		newTo = newFrom
+	}
+	return newFrom, newTo
+}
+
+// Invert returns the steps that undo this transform: the inverted steps, in
+// reverse order, which turn the current document back into the starting
+// document when they are applied to it.
+func (tr *Transform) Invert() []Step {
+	inverted := make([]Step, 0, len(tr.Steps))
+	for i := len(tr.Steps) - 1; i >= 0; i-- {
+		inverted = append(inverted, tr.Steps[i].Invert(tr.Docs[i]))
+	}
+	return inverted
+}
+
+func (tr *Transform) addStep(step Step, doc *model.Node) {
+	tr.Docs = append(tr.Docs, tr.Doc)
+	tr.Steps = append(tr.Steps, step)
+	tr.Mapping.AppendMap(step.GetMap())
+	tr.Doc = doc
+}
+
+// Replace the part of the document between from and to with the given slice.
+// Unlike prosemirror, the slice is not fitted: it must already fit the gap
+// between from and to.
+func (tr *Transform) Replace(from, to int, slice *model.Slice) error {
+	if from == to && slice.Size() == 0 {
+		return nil
+	}
+	return tr.Step(NewReplaceStep(from, to, slice))
+}
+
+// ReplaceStripping is like Replace, but the marks of the inline content of the
+// slice that are not allowed by the nodes where this content lands are
+// removed first, like an editor does when pasting.
+func (tr *Transform) ReplaceStripping(from, to int, slice *model.Slice) error {
+	rFrom, err := tr.Doc.Resolve(from)
+	if err != nil {
+		return err
+	}
+	if slice.OpenStart > rFrom.Depth {
+		return &TransformError{Message: "Slice is too deep for the replaced range"}
+	}
+	parent := rFrom.Node(rFrom.Depth - slice.OpenStart).Type
+	content := stripMarks(slice.Content, parent, rFrom, slice.OpenStart)
+	if content != slice.Content {
+		slice = model.NewSlice(content, slice.OpenStart, slice.OpenEnd)
+	}
+	return tr.Replace(from, to, slice)
+}
+
+// stripMarks removes the marks not allowed by parent from the inline nodes of
+// frag, and recursively in the other nodes. The first openStart nodes on the
+// left side of the fragment are open, and are joined to the ancestors of
+// rFrom: their content lands in nodes of the types of these ancestors.
+func stripMarks(frag *model.Fragment, parent *model.NodeType, rFrom *model.ResolvedPos, openStart int) *model.Fragment {
+	var nodes []*model.Node
+	changed := false
+	for i, child := range frag.Content {
+		stripped := child
+		if child.IsInline() {
+			var allowed []*model.Mark
+			for _, mark := range child.Marks {
+				if parent.AllowsMarkType(mark.Type) {
+					allowed = append(allowed, mark)
+				}
+			}
+			if len(allowed) < len(child.Marks) {
+				stripped = child.Mark(allowed)
+			}
+		} else if child.Content.Size > 0 {
+			typ, open := child.Type, 0
+			if i == 0 && openStart > 0 {
+				typ, open = rFrom.Node(rFrom.Depth-openStart+1).Type, openStart-1
+			}
+			content := stripMarks(child.Content, typ, rFrom, open)
+			if content != child.Content {
+				stripped = child.Copy(content)
+			}
+		}
+		if stripped != child {
+			changed = true
+		}
+		nodes = append(nodes, stripped)
+	}
+	if !changed {
+		return frag
+	}
+	return model.FragmentFromArray(nodes)
+}
+
+// Delete the content between the given positions.
+func (tr *Transform) Delete(from, to int) error {
+	return tr.Replace(from, to, model.EmptySlice)
+}
+
+// SetDocAttribute sets the value of an attribute of the top-level node of the
+// document.
+func (tr *Transform) SetDocAttribute(attr string, value interface{}) error {
+	return tr.Step(NewDocAttrStep(attr, value))
+}
+
+// InsertInline inserts the given inline node at the given position. When
+// inheritMarks is true, the node gets the marks at that position (as returned
+// by ResolvedPos.Marks), like text typed there would.
+func (tr *Transform) InsertInline(pos int, node *model.Node, inheritMarks bool) error {
+	if !node.IsInline() {
+		return &TransformError{Message: "InsertInline can only insert inline nodes"}
+	}
+	if inheritMarks {
+		rpos, err := tr.Doc.Resolve(pos)
+		if err != nil {
+			return err
+		}
+		node = node.Mark(rpos.Marks())
+	}
+	fragment, err := model.FragmentFrom(node)
+	if err != nil {
+		return err
+	}
+	return tr.Replace(pos, pos, model.NewSlice(fragment, 0, 0))
+}
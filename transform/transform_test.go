@@ -0,0 +1,125 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/test/builder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformMapSelection(t *testing.T) {
+	insert := func(tr *Transform, pos int, text string) {
+		frag, err := model.FragmentFrom(schema.Text(text))
+		assert.NoError(t, err)
+		assert.NoError(t, tr.Replace(pos, pos, model.NewSlice(frag, 0, 0)))
+	}
+
+	// shifts both endpoints after an insertion before the selection
+	tr := NewTransform(doc(p("hello world")).Node)
+	insert(tr, 1, "abc")
+	from, to := tr.MapSelection(7, 12)
+	assert.Equal(t, 10, from)
+	assert.Equal(t, 15, to)
+
+	// maps through all the steps
+	insert(tr, 1, "xy")
+	assert.NoError(t, tr.Delete(1, 2))
+	from, to = tr.MapSelection(7, 12)
+	assert.Equal(t, 11, from)
+	assert.Equal(t, 16, to)
+
+	// doesn't extend the selection with content inserted at its edges
+	tr = NewTransform(doc(p("hello world")).Node)
+	insert(tr, 7, "abc")
+	insert(tr, 15, "def")
+	from, to = tr.MapSelection(7, 12)
+	assert.Equal(t, 10, from)
+	assert.Equal(t, 15, to)
+
+	// collapses a deleted selection
+	tr = NewTransform(doc(p("hello world")).Node)
+	assert.NoError(t, tr.Delete(2, 10))
+	from, to = tr.MapSelection(4, 6)
+	assert.Equal(t, 2, from)
+	assert.Equal(t, 2, to)
+}
+
+func TestTransformInsertInline(t *testing.T) {
+	image := img().Node
+
+	// inherits the marks at the position
+	tr := NewTransform(doc(p("a", em("bcd"), "e")).Node)
+	assert.NoError(t, tr.InsertInline(3, image, true))
+	expected := doc(p("a", em("b", img, "cd"), "e")).Node
+	assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc.String(), expected.String())
+
+	// doesn't add marks when not asked to
+	tr = NewTransform(doc(p("a", em("bcd"), "e")).Node)
+	assert.NoError(t, tr.InsertInline(3, image, false))
+	expected = doc(p("a", em("b"), img, em("cd"), "e")).Node
+	assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc.String(), expected.String())
+
+	// refuses block nodes
+	tr = NewTransform(doc(p("a")).Node)
+	assert.Error(t, tr.InsertInline(1, p("b").Node, true))
+	assert.False(t, tr.DocChanged())
+}
+
+func TestTransformReplaceStripping(t *testing.T) {
+	pre := builder.Pre
+
+	// removes the marks that the target node doesn't allow
+	slice, err := doc(p("x", strong("bold"), em("y"))).Node.Slice(1, 7)
+	require.NoError(t, err)
+	tr := NewTransform(doc(pre("code")).Node)
+	assert.Error(t, tr.Replace(3, 3, slice))
+	require.NoError(t, tr.ReplaceStripping(3, 3, slice))
+	expected := doc(pre("coxboldyde")).Node
+	assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc, expected)
+
+	// keeps the marks allowed by the target node
+	tr = NewTransform(doc(p("ab")).Node)
+	require.NoError(t, tr.ReplaceStripping(2, 2, slice))
+	expected = doc(p("ax", strong("bold"), em("y"), "b")).Node
+	assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc, expected)
+
+	// uses the type of the node to which an open node is joined
+	slice, err = doc(p(strong("ab")), p(em("cd"))).Node.Slice(2, 6)
+	require.NoError(t, err)
+	tr = NewTransform(doc(pre("xy"), p("z")).Node)
+	require.NoError(t, tr.ReplaceStripping(2, 2, slice))
+	expected = doc(pre("xb"), p(em("c"), "y"), p("z")).Node
+	assert.True(t, tr.Doc.Eq(expected), "%s != %s\n", tr.Doc, expected)
+}
+
+func TestTransformInvert(t *testing.T) {
+	start := doc(p("hello ", em("world")), h1("title")).Node
+	tr := NewTransform(start)
+	assert.NoError(t, tr.InsertInline(1, schema.Text("oh, "), false))
+	assert.NoError(t, tr.Step(NewAddMarkStep(1, 5, schema.Mark("strong"))))
+	assert.NoError(t, tr.RemoveMark(11, 16, schema.Mark("em")))
+	assert.NoError(t, tr.Step(NewSetAttrsStep(17, map[string]interface{}{"level": 2})))
+	assert.NoError(t, tr.Delete(7, 10))
+	assert.Len(t, tr.Steps, 5)
+
+	inverted := tr.Invert()
+	assert.Len(t, inverted, 5)
+
+	// recovers the starting document
+	undo := NewTransform(tr.Doc)
+	for _, step := range inverted {
+		assert.NoError(t, undo.Step(step))
+	}
+	assert.True(t, undo.Doc.Eq(start), "%s != %s", undo.Doc, start)
+
+	// goes through the intermediate documents in reverse order
+	for i := 1; i < len(undo.Docs); i++ {
+		expected := tr.Docs[len(tr.Docs)-i]
+		assert.True(t, undo.Docs[i].Eq(expected), "%s != %s", undo.Docs[i], expected)
+	}
+
+	// returns no steps for an empty transform
+	assert.Empty(t, NewTransform(start).Invert())
+}